@@ -0,0 +1,116 @@
+// Package errors 定义 chatlog 内部各层共用的错误类型：简单的判等哨兵错误
+// （Err 前缀）用于调用方需要分支处理的场景（比如 resolver 链的跳过语义），
+// 其余是带上下文信息的构造函数，统一在外层用 log.Err(err) 或直接返回给
+// MCP/HTTP 调用方。
+package errors
+
+import (
+	"fmt"
+	"time"
+)
+
+// 哨兵错误：调用方用 == 或 errors.Is 判断，不是用来展示给用户看的
+var (
+	// ErrTalkerEmpty 表示接口要求的 talker 参数为空
+	ErrTalkerEmpty = fmt.Errorf("talker 不能为空")
+	// ErrQueryEmpty 表示检索接口要求的 query 参数为空
+	ErrQueryEmpty = fmt.Errorf("查询内容不能为空")
+	// ErrKeyEmpty 表示媒体查找接口要求的 key 参数为空
+	ErrKeyEmpty = fmt.Errorf("key 不能为空")
+	// ErrKeyLengthMust32 表示媒体 key 不是合法的 32 位 md5
+	ErrKeyLengthMust32 = fmt.Errorf("key 长度必须为 32")
+	// ErrMediaNotFound 表示所有 MediaResolver 都没有找到对应的媒体文件
+	ErrMediaNotFound = fmt.Errorf("媒体文件未找到")
+	// ErrMediaResolverSkip 由 MediaResolver.Open 返回，表示这个 Media 不是自己
+	// 解析出来的，OpenMedia 应该交给链上下一个解析器
+	ErrMediaResolverSkip = fmt.Errorf("当前解析器无法处理该媒体文件")
+	// ErrFTSIndexUnavailableReadOnly 表示 DataSourceOptions.ReadOnly 为 true 时
+	// FTS5 虚拟表尚不存在，且只读连接不允许临时建出来
+	ErrFTSIndexUnavailableReadOnly = fmt.Errorf("只读模式下 FTS 索引不可用")
+)
+
+// DBFileNotFound 表示按 pattern 在 path 下没能找到需要的数据库文件
+func DBFileNotFound(path, pattern string, err error) error {
+	if err != nil {
+		return fmt.Errorf("在 %s 下按 %s 查找数据库文件失败: %w", path, pattern, err)
+	}
+	return fmt.Errorf("在 %s 下没有找到匹配 %s 的数据库文件", path, pattern)
+}
+
+// DBConnectFailed 表示打开 filePath 对应的 sqlite 连接失败
+func DBConnectFailed(filePath string, err error) error {
+	if err != nil {
+		return fmt.Errorf("连接数据库 %s 失败: %w", filePath, err)
+	}
+	return fmt.Errorf("数据库 %s 未连接", filePath)
+}
+
+// DBCloseFailed 表示关闭某个数据库连接时出错
+func DBCloseFailed(err error) error {
+	return fmt.Errorf("关闭数据库连接失败: %w", err)
+}
+
+// DBInitFailed 表示 New 初始化某个子数据库（消息/联系人/会话/媒体）失败
+func DBInitFailed(err error) error {
+	return fmt.Errorf("初始化数据库失败: %w", err)
+}
+
+// QueryFailed 表示执行 query 失败，query 为空时错误信息里不带具体语句（比如查
+// sqlite_master 的探测性查询）
+func QueryFailed(query string, err error) error {
+	if query == "" {
+		return fmt.Errorf("查询失败: %w", err)
+	}
+	return fmt.Errorf("执行查询 %q 失败: %w", query, err)
+}
+
+// ScanRowFailed 表示 rows.Scan 失败
+func ScanRowFailed(err error) error {
+	return fmt.Errorf("读取查询结果失败: %w", err)
+}
+
+// TimeRangeNotFound 表示时间范围 [start, end) 内没有找到任何相关的数据库分片
+func TimeRangeNotFound(start, end time.Time) error {
+	return fmt.Errorf("时间范围 [%s, %s) 内没有找到数据", start.Format(time.RFC3339), end.Format(time.RFC3339))
+}
+
+// MediaTypeUnsupported 表示请求了不认识的媒体类型（image/video/file 之外）
+func MediaTypeUnsupported(mediaType string) error {
+	return fmt.Errorf("不支持的媒体类型: %s", mediaType)
+}
+
+// MediaBackendUnsupported 表示 MediaConfig.Backend 不是已知的远程对象存储后端
+func MediaBackendUnsupported(backend string) error {
+	return fmt.Errorf("不支持的媒体后端: %s", backend)
+}
+
+// MediaBackendInitFailed 表示初始化某个远程对象存储客户端失败
+func MediaBackendInitFailed(backend string, err error) error {
+	return fmt.Errorf("初始化媒体后端 %s 失败: %w", backend, err)
+}
+
+// MediaBackendRequestFailed 表示调用某个远程对象存储 SDK 失败
+func MediaBackendRequestFailed(backend string, err error) error {
+	return fmt.Errorf("请求媒体后端 %s 失败: %w", backend, err)
+}
+
+// StatsGroupByUnsupported 表示 StatsRequest.GroupBy 不是已知的分组维度
+func StatsGroupByUnsupported(groupBy string) error {
+	return fmt.Errorf("不支持的统计分组维度: %s", groupBy)
+}
+
+// StatsMetricUnsupported 表示 StatsRequest.Metric 不是已知的统计指标
+func StatsMetricUnsupported(metric string) error {
+	return fmt.Errorf("不支持的统计指标: %s", metric)
+}
+
+// CursorInvalid 表示 ParseCursor 收到的游标字符串格式不对，或者 ListMessages
+// 收到的游标指向的分片已经不在当前的分片列表里
+func CursorInvalid(cursor string) error {
+	return fmt.Errorf("游标格式不合法: %q", cursor)
+}
+
+// ExportFormatUnsupported 表示 Export 收到的格式不是 jsonl/csv/markdown 之一
+func ExportFormatUnsupported(format string) error {
+	return fmt.Errorf("不支持的导出格式: %s", format)
+}
@@ -0,0 +1,36 @@
+package model
+
+// ChatRoomUser 是群聊成员在群内的身份信息
+type ChatRoomUser struct {
+	UserName    string
+	DisplayName string
+}
+
+// ChatRoom 是 GetChatRooms 对外暴露的群聊结构
+type ChatRoom struct {
+	Name  string
+	Owner string
+	Users []ChatRoomUser
+	// User2DisplayName 把成员 UserName 映射到群内显示的昵称，查不到时退化为
+	// UserName 本身
+	User2DisplayName map[string]string
+}
+
+// ChatRoomV4 是 contact.db 里 chat_room 表的行结构。ExtBuffer 是一段按成员
+// 拼接的紧凑二进制信息，真正的成员列表/群内昵称需要额外解析，这里只做最基础
+// 的字段搬运
+type ChatRoomV4 struct {
+	UserName  string
+	Owner     string
+	ExtBuffer []byte
+}
+
+// Wrap 把一行 ChatRoomV4 转换成对外的 ChatRoom
+func (c *ChatRoomV4) Wrap() *ChatRoom {
+	return &ChatRoom{
+		Name:             c.UserName,
+		Owner:            c.Owner,
+		Users:            make([]ChatRoomUser, 0),
+		User2DisplayName: make(map[string]string),
+	}
+}
@@ -0,0 +1,30 @@
+package model
+
+// Contact 是 GetContacts 对外暴露的联系人结构
+type Contact struct {
+	UserName  string
+	LocalType int
+	Alias     string
+	Remark    string
+	NickName  string
+}
+
+// ContactV4 是 contact.db 里 contact 表的行结构
+type ContactV4 struct {
+	UserName  string
+	LocalType int
+	Alias     string
+	Remark    string
+	NickName  string
+}
+
+// Wrap 把一行 ContactV4 转换成对外的 Contact
+func (c *ContactV4) Wrap() *Contact {
+	return &Contact{
+		UserName:  c.UserName,
+		LocalType: c.LocalType,
+		Alias:     c.Alias,
+		Remark:    c.Remark,
+		NickName:  c.NickName,
+	}
+}
@@ -0,0 +1,52 @@
+package model
+
+// Media 是 GetMedia/OpenMedia 对外暴露的媒体文件描述，不管是本地硬链接还是远程
+// 对象存储命中的，都统一成这个结构
+type Media struct {
+	// Type 是 image/video/file 之一
+	Type string
+	// Key 是媒体的 md5，GetMedia 的查找条件
+	Key string
+	// Name 是文件名（本地硬链接）或对象 key（远程存储）
+	Name string
+	// Size 是文件大小，单位字节
+	Size int64
+	// Path 是可以直接读取的地址：本地硬链接是文件系统路径，远程存储是带签名
+	// 的临时下载 URL
+	Path string
+	// ModifyTime 是文件最后修改时间，unix 时间戳
+	ModifyTime int64
+	// Dir1/Dir2 是本地硬链接目录结构的两级子目录，拼接出 Path 之后就不再需要，
+	// 只有 localHardlinkResolver 会用到
+	Dir1 string
+	Dir2 string
+	// Source 是产出这个 Media 的 MediaResolver.Name()，OpenMedia 据此直接把
+	// 请求派发回正确的解析器，不依赖 os.Open/os.IsNotExist 之类的系统调用副作用
+	Source string
+}
+
+// MediaV4 是本地硬链接库（hardlink.db）里 image/video/file_hardlink_info_v3
+// 表的行结构
+type MediaV4 struct {
+	Type       string
+	Key        string
+	Name       string
+	Size       int64
+	ModifyTime int64
+	Dir1       string
+	Dir2       string
+}
+
+// Wrap 把一行 MediaV4 转换成对外的 Media，Path 由调用方在拿到 Dir1/Dir2 之后
+// 自己拼出来
+func (m *MediaV4) Wrap() *Media {
+	return &Media{
+		Type:       m.Type,
+		Key:        m.Key,
+		Name:       m.Name,
+		Size:       m.Size,
+		ModifyTime: m.ModifyTime,
+		Dir1:       m.Dir1,
+		Dir2:       m.Dir2,
+	}
+}
@@ -0,0 +1,59 @@
+package model
+
+import "time"
+
+// Message 是各版本消息表统一对外暴露的结构，search.go/export.go 等上层逻辑只
+// 认这个类型，具体数据库 schema 的差异由各版本自己的 WrapXXX/MessageV4 之类的
+// 中间结构屏蔽掉
+type Message struct {
+	// Seq 是消息在所属会话内的排序序号，对应 Msg_<md5> 表的 sort_seq，
+	// ListMessages/StreamMessages 的游标以它为准
+	Seq int64
+	// Talker 是这条消息所属的会话（md5 反解前的原始 key，由调用方传入）
+	Talker string
+	// Sender 是发送者的 user_name，群聊消息由 Name2Id 表联查得到，单聊消息通常
+	// 和 Talker 相同
+	Sender string
+	Time   time.Time
+	// Type 对应 local_type，由客户端定义的消息类型编码（文本/图片/语音/撤回等）
+	Type int
+	// Content 是消息正文，文本消息就是纯文本，富媒体消息是一段 XML/JSON
+	Content string
+	// Status 对应消息状态（已发送/已撤回等），具体取值由客户端定义
+	Status int
+	// Snippet 是 SearchMessages 命中时 FTS5 snippet() 函数生成的带高亮片段，
+	// 非检索场景下为空
+	Snippet string
+}
+
+// PlainText 返回适合直接展示/导出的纯文本内容。富媒体消息的 Content 是一段
+// XML，这里先只处理最常见的纯文本场景，其余类型原样返回
+func (m *Message) PlainText() string {
+	return m.Content
+}
+
+// MessageV4 是 v4 版消息表（message_*.db 里的 Msg_<md5> 表）的行结构，字段名
+// 和列名一一对应，方便 rows.Scan 直接填充
+type MessageV4 struct {
+	SortSeq        int64
+	LocalType      int
+	UserName       string
+	CreateTime     int64
+	MessageContent string
+	PackedInfoData []byte
+	Status         int
+}
+
+// Wrap 把一行 MessageV4 转换成对外的 Message，talker 由调用方传入（表名里的
+// md5 不可逆，必须由上层告诉我们这是哪个会话）
+func (m *MessageV4) Wrap(talker string) *Message {
+	return &Message{
+		Seq:     m.SortSeq,
+		Talker:  talker,
+		Sender:  m.UserName,
+		Time:    time.Unix(m.CreateTime, 0),
+		Type:    m.LocalType,
+		Content: m.MessageContent,
+		Status:  m.Status,
+	}
+}
@@ -0,0 +1,30 @@
+package model
+
+// Session 是 GetSessions 对外暴露的最近会话结构
+type Session struct {
+	Username              string
+	Summary               string
+	LastTimestamp         int64
+	LastMsgSender         string
+	LastSenderDisplayName string
+}
+
+// SessionV4 是 session.db 里 SessionTable 表的行结构
+type SessionV4 struct {
+	Username              string
+	Summary               string
+	LastTimestamp         int64
+	LastMsgSender         string
+	LastSenderDisplayName string
+}
+
+// Wrap 把一行 SessionV4 转换成对外的 Session
+func (s *SessionV4) Wrap() *Session {
+	return &Session{
+		Username:              s.Username,
+		Summary:               s.Summary,
+		LastTimestamp:         s.LastTimestamp,
+		LastMsgSender:         s.LastMsgSender,
+		LastSenderDisplayName: s.LastSenderDisplayName,
+	}
+}
@@ -6,10 +6,14 @@ import (
 	"database/sql"
 	"encoding/hex"
 	"fmt"
+	"io"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
+	// search.go 的 SearchMessages 用到了 FTS5 虚拟表，构建/测试本包时必须加上
+	// `-tags sqlite_fts5`，否则这个驱动编译进来的 SQLite 不带 FTS5 扩展
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/rs/zerolog/log"
 
@@ -39,15 +43,67 @@ type DataSource struct {
 	sessionDb  *sql.DB
 	mediaDb    *sql.DB
 
+	// mu 保护 messageFiles 和 messageDbs：watchMessageDbs 在后台发现新增/移除的
+	// message_*.db 分片时加写锁更新，所有读路径（GetMessages、SearchMessages、
+	// GetStats...）加读锁，保证重载期间看到的是一致的快照
+	mu sync.RWMutex
 	// 消息数据库信息
 	messageFiles []MessageDBInfo
+
+	// opts 控制连接池参数与热重载扫描间隔，参见 DataSourceOptions
+	opts DataSourceOptions
+	// stopWatch 关闭后 watchMessageDbs 的后台协程退出
+	stopWatch chan struct{}
+
+	// ftsMu 保护 ftsTriggersOK，记录每个分片里的 Msg_<md5>_fts 表是否已经挂上了
+	// 保持索引同步的触发器；key 是 ftsTriggersKey(filePath, ftsTable)，因为每个
+	// message_N.db 分片都有自己的一份同名表，可写性逐分片不同，不能共用一个 key。
+	// 数据库只读时触发器无法创建，置为 false 以便查询时改用按需重建的方式兜底
+	ftsMu         sync.Mutex
+	ftsTriggersOK map[string]bool
+
+	// mediaResolvers 是 GetMedia/OpenMedia 依次尝试的解析链，默认只有本地
+	// 硬链接解析器；配置了 MediaConfig 后追加对应的远程对象存储解析器
+	mediaResolvers []MediaResolver
+
+	// statsCache 缓存 GetStats 按分片计算出的结果，key 包含文件 mtime，老分片
+	// 不会再变化，缓存可以一直复用
+	statsCache *statsLRU
+}
+
+// statsCacheSize 是 statsCache 的默认容量：每个分片 x 每种 GroupBy/Metric 组合
+// 都是一条缓存项，256 条足以覆盖常见的仪表盘刷新场景
+const statsCacheSize = 256
+
+// Option 是 New 的函数式选项，用于在不破坏既有 New(path) 调用方式的前提下
+// 扩展可选配置
+type Option func(*options)
+
+type options struct {
+	mediaConfig *MediaConfig
+	dsOptions   *DataSourceOptions
 }
 
-func New(path string) (*DataSource, error) {
+func New(path string, opts ...Option) (*DataSource, error) {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	dsOpts := DataSourceOptions{}
+	if o.dsOptions != nil {
+		dsOpts = *o.dsOptions
+	}
+	dsOpts = dsOpts.withDefaults()
+
 	ds := &DataSource{
-		path:         path,
-		messageDbs:   make(map[string]*sql.DB),
-		messageFiles: make([]MessageDBInfo, 0),
+		path:          path,
+		messageDbs:    make(map[string]*sql.DB),
+		messageFiles:  make([]MessageDBInfo, 0),
+		ftsTriggersOK: make(map[string]bool),
+		statsCache:    newStatsLRU(statsCacheSize),
+		opts:          dsOpts,
+		stopWatch:     make(chan struct{}),
 	}
 
 	if err := ds.initMessageDbs(path); err != nil {
@@ -62,6 +118,11 @@ func New(path string) (*DataSource, error) {
 	if err := ds.initMediaDb(path); err != nil {
 		return nil, errors.DBInitFailed(err)
 	}
+	if err := ds.initMediaResolvers(path, o.mediaConfig); err != nil {
+		return nil, errors.DBInitFailed(err)
+	}
+
+	go ds.watchMessageDbs(path)
 
 	return ds, nil
 }
@@ -86,6 +147,12 @@ func (ds *DataSource) initMessageDbs(path string) error {
 			continue
 		}
 
+		if err := configureMessageDB(db, ds.opts); err != nil {
+			log.Err(err).Msgf("配置数据库 %s 失败", filePath)
+			db.Close()
+			continue
+		}
+
 		// 获取 Timestamp 表中的开始时间
 		var startTime time.Time
 		var timestamp int64
@@ -175,6 +242,9 @@ func (ds *DataSource) initMediaDb(path string) error {
 
 // getDBInfosForTimeRange 获取时间范围内的数据库信息
 func (ds *DataSource) getDBInfosForTimeRange(startTime, endTime time.Time) []MessageDBInfo {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+
 	var dbs []MessageDBInfo
 	for _, info := range ds.messageFiles {
 		if info.StartTime.Before(endTime) && info.EndTime.After(startTime) {
@@ -184,6 +254,16 @@ func (ds *DataSource) getDBInfosForTimeRange(startTime, endTime time.Time) []Mes
 	return dbs
 }
 
+// getMessageDB 以读锁获取某个分片当前打开的连接，热重载期间 watchMessageDbs
+// 会在写锁下替换 messageDbs，这里始终读到一致的快照
+func (ds *DataSource) getMessageDB(filePath string) (*sql.DB, bool) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+
+	db, ok := ds.messageDbs[filePath]
+	return db, ok
+}
+
 func (ds *DataSource) GetMessages(ctx context.Context, startTime, endTime time.Time, talker string, limit, offset int) ([]*model.Message, error) {
 	if talker == "" {
 		return nil, errors.ErrTalkerEmpty
@@ -209,7 +289,7 @@ func (ds *DataSource) GetMessages(ctx context.Context, startTime, endTime time.T
 			return nil, err
 		}
 
-		db, ok := ds.messageDbs[dbInfo.FilePath]
+		db, ok := ds.getMessageDB(dbInfo.FilePath)
 		if !ok {
 			log.Error().Msgf("数据库 %s 未打开", dbInfo.FilePath)
 			continue
@@ -250,7 +330,7 @@ func (ds *DataSource) GetMessages(ctx context.Context, startTime, endTime time.T
 
 // getMessagesSingleFile 从单个数据库文件获取消息
 func (ds *DataSource) getMessagesSingleFile(ctx context.Context, dbInfo MessageDBInfo, startTime, endTime time.Time, talker string, limit, offset int) ([]*model.Message, error) {
-	db, ok := ds.messageDbs[dbInfo.FilePath]
+	db, ok := ds.getMessageDB(dbInfo.FilePath)
 	if !ok {
 		return nil, errors.DBConnectFailed(dbInfo.FilePath, nil)
 	}
@@ -600,6 +680,8 @@ func (ds *DataSource) GetSessions(ctx context.Context, key string, limit, offset
 	return sessions, nil
 }
 
+// GetMedia 依次尝试 mediaResolvers 中的解析器（本地硬链接优先），返回第一个
+// 命中的媒体文件信息
 func (ds *DataSource) GetMedia(ctx context.Context, _type string, key string) (*model.Media, error) {
 	if key == "" {
 		return nil, errors.ErrKeyEmpty
@@ -609,75 +691,57 @@ func (ds *DataSource) GetMedia(ctx context.Context, _type string, key string) (*
 		return nil, errors.ErrKeyLengthMust32
 	}
 
-	var table string
-	switch _type {
-	case "image":
-		table = "image_hardlink_info_v3"
-	case "video":
-		table = "video_hardlink_info_v3"
-	case "file":
-		table = "file_hardlink_info_v3"
-	default:
-		return nil, errors.MediaTypeUnsupported(_type)
-	}
-
-	query := fmt.Sprintf(`
-	SELECT 
-		f.md5,
-		f.file_name,
-		f.file_size,
-		f.modify_time,
-		IFNULL(d1.username,""),
-		IFNULL(d2.username,"")
-	FROM 
-		%s f
-	LEFT JOIN 
-		dir2id d1 ON d1.rowid = f.dir1
-	LEFT JOIN 
-		dir2id d2 ON d2.rowid = f.dir2
-	`, table)
-	query += " WHERE f.md5 = ? OR f.file_name LIKE ? || '%'"
-	args := []interface{}{key, key}
-
-	rows, err := ds.mediaDb.QueryContext(ctx, query, args...)
-	if err != nil {
-		return nil, errors.QueryFailed(query, err)
-	}
-	defer rows.Close()
-
-	var media *model.Media
-	for rows.Next() {
-		var mediaV4 model.MediaV4
-		err := rows.Scan(
-			&mediaV4.Key,
-			&mediaV4.Name,
-			&mediaV4.Size,
-			&mediaV4.ModifyTime,
-			&mediaV4.Dir1,
-			&mediaV4.Dir2,
-		)
+	for _, resolver := range ds.mediaResolvers {
+		media, err := resolver.Lookup(ctx, _type, key)
 		if err != nil {
-			return nil, errors.ScanRowFailed(err)
+			if err != errors.ErrMediaNotFound {
+				log.Err(err).Msgf("解析器 %s 查找媒体文件失败", resolver.Name())
+			}
+			continue
 		}
-		mediaV4.Type = _type
-		media = mediaV4.Wrap()
+		return media, nil
+	}
 
-		// 跳过缩略图
-		if _type == "image" && !strings.Contains(media.Name, "_t") {
-			break
+	return nil, errors.ErrMediaNotFound
+}
+
+// OpenMedia 按 GetMedia 返回的 Media 打开可读数据流，本地硬链接走文件系统，
+// 远程对象存储走对应 SDK 下载，调用方无需关心具体后端。优先按 Media.Source
+// 直接派发给产出它的那个解析器，这是 Lookup 时写下的显式标记，不依赖
+// os.Open/os.IsNotExist 之类的系统调用副作用去猜测归属（在 Windows 上语义也
+// 不保证一致，而 WeChat 正是跑在 Windows 上）
+func (ds *DataSource) OpenMedia(ctx context.Context, media *model.Media) (io.ReadCloser, error) {
+	if media.Source != "" {
+		for _, resolver := range ds.mediaResolvers {
+			if resolver.Name() == media.Source {
+				return resolver.Open(ctx, media)
+			}
 		}
 	}
 
-	if media == nil {
-		return nil, errors.ErrMediaNotFound
+	// Media.Source 为空或找不到对应解析器（比如跨版本缓存下来的 Media，或者
+	// mediaResolvers 的配置变了），退化为依次尝试，沿用 ErrMediaResolverSkip 语义
+	for _, resolver := range ds.mediaResolvers {
+		r, err := resolver.Open(ctx, media)
+		if err != nil {
+			if err == errors.ErrMediaResolverSkip {
+				continue
+			}
+			return nil, err
+		}
+		return r, nil
 	}
-
-	return media, nil
+	return nil, errors.ErrMediaNotFound
 }
 
 func (ds *DataSource) Close() error {
+	close(ds.stopWatch)
+
 	var errs []error
 
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
 	// 关闭消息数据库连接
 	for _, db := range ds.messageDbs {
 		if err := db.Close(); err != nil {
@@ -0,0 +1,338 @@
+package v4
+
+import (
+	"context"
+	"crypto/md5"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/model"
+)
+
+// 导出格式
+const (
+	ExportFormatJSONL    = "jsonl"
+	ExportFormatCSV      = "csv"
+	ExportFormatMarkdown = "markdown"
+)
+
+// MessagesRequest 描述一次消息查询/导出的时间范围与会话
+type MessagesRequest struct {
+	StartTime time.Time
+	EndTime   time.Time
+	Talker    string
+}
+
+// Cursor 编码 ListMessages 分页游标的位置：分片的 FilePath/StartTime（而不是它
+// 在 getDBInfosForTimeRange 返回的 slice 里的下标）、以及该分片内读到的最后一条
+// sort_seq。下标会因为两次分页调用之间 hot-reload 增删了分片而漂移，指向错误的
+// 分片；FilePath+StartTime 标识的是分片本身，热重载前后不变，所以游标换成按它
+// 们定位，定位不到就说明分片已经被移除，视为游标失效
+type Cursor struct {
+	FilePath    string
+	StartTime   int64
+	LastSortSeq int64
+}
+
+func (c Cursor) String() string {
+	return fmt.Sprintf("%d:%d:%s", c.StartTime, c.LastSortSeq, c.FilePath)
+}
+
+// ParseCursor 解析 ListMessages 返回的游标，空字符串表示从头开始
+func ParseCursor(s string) (Cursor, error) {
+	if s == "" {
+		return Cursor{}, nil
+	}
+
+	// FilePath 放在最后、用 SplitN 限制成 3 段，这样路径本身带冒号（比如
+	// Windows 的 `C:\...`）也不会被拆散
+	parts := strings.SplitN(s, ":", 3)
+	if len(parts) != 3 || parts[2] == "" {
+		return Cursor{}, errors.CursorInvalid(s)
+	}
+
+	startTime, err1 := strconv.ParseInt(parts[0], 10, 64)
+	lastSortSeq, err2 := strconv.ParseInt(parts[1], 10, 64)
+	if err1 != nil || err2 != nil {
+		return Cursor{}, errors.CursorInvalid(s)
+	}
+
+	return Cursor{FilePath: parts[2], StartTime: startTime, LastSortSeq: lastSortSeq}, nil
+}
+
+// StreamMessages 按时间顺序遍历 getDBInfosForTimeRange 选出的分片，把扫描到的
+// 消息逐条推入返回的 channel，不在内存里攒完整的结果集，用于大规模导出。调用方
+// 读完 msgCh 后应该检查 errCh 是否有错误。
+func (ds *DataSource) StreamMessages(ctx context.Context, req MessagesRequest) (<-chan *model.Message, <-chan error) {
+	msgCh := make(chan *model.Message, 64)
+	errCh := make(chan error, 1)
+
+	go func() {
+		defer close(errCh)
+		defer close(msgCh)
+
+		if req.Talker == "" {
+			errCh <- errors.ErrTalkerEmpty
+			return
+		}
+
+		dbInfos := ds.getDBInfosForTimeRange(req.StartTime, req.EndTime)
+		if len(dbInfos) == 0 {
+			errCh <- errors.TimeRangeNotFound(req.StartTime, req.EndTime)
+			return
+		}
+
+		talkerMd5Bytes := md5.Sum([]byte(req.Talker))
+		tableName := "Msg_" + hex.EncodeToString(talkerMd5Bytes[:])
+
+		for _, dbInfo := range dbInfos {
+			if err := ctx.Err(); err != nil {
+				errCh <- err
+				return
+			}
+
+			db, ok := ds.getMessageDB(dbInfo.FilePath)
+			if !ok {
+				log.Error().Msgf("数据库 %s 未打开", dbInfo.FilePath)
+				continue
+			}
+
+			_, err := ds.scanMessages(ctx, db, tableName, req.Talker, req.StartTime, req.EndTime, 0, 0,
+				func(msg *model.Message) bool {
+					select {
+					case msgCh <- msg:
+						return true
+					case <-ctx.Done():
+						return false
+					}
+				})
+			if err != nil {
+				errCh <- err
+				return
+			}
+		}
+	}()
+
+	return msgCh, errCh
+}
+
+// ListMessages 是 StreamMessages 的游标分页版本：每次只取一页，返回用于取下一
+// 页的游标，游标为空字符串表示没有更多数据。两次调用之间如果发生了 hot-reload，
+// 游标里的分片可能已经不在 getDBInfosForTimeRange 新算出的结果里了，此时视为游
+// 标失效返回 errors.CursorInvalid，而不是静默地指向错误的分片
+func (ds *DataSource) ListMessages(ctx context.Context, req MessagesRequest, cursor string, limit int) ([]*model.Message, string, error) {
+	if req.Talker == "" {
+		return nil, "", errors.ErrTalkerEmpty
+	}
+	if limit <= 0 {
+		limit = 100
+	}
+
+	start, err := ParseCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	dbInfos := ds.getDBInfosForTimeRange(req.StartTime, req.EndTime)
+	if len(dbInfos) == 0 {
+		return nil, "", errors.TimeRangeNotFound(req.StartTime, req.EndTime)
+	}
+
+	startIdx := 0
+	if start.FilePath != "" {
+		startIdx = -1
+		for i, dbInfo := range dbInfos {
+			if dbInfo.FilePath == start.FilePath && dbInfo.StartTime.Unix() == start.StartTime {
+				startIdx = i
+				break
+			}
+		}
+		if startIdx == -1 {
+			return nil, "", errors.CursorInvalid(cursor)
+		}
+	}
+
+	talkerMd5Bytes := md5.Sum([]byte(req.Talker))
+	tableName := "Msg_" + hex.EncodeToString(talkerMd5Bytes[:])
+
+	messages := make([]*model.Message, 0, limit)
+
+	for i := startIdx; i < len(dbInfos); i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, "", err
+		}
+
+		db, ok := ds.getMessageDB(dbInfos[i].FilePath)
+		if !ok {
+			log.Error().Msgf("数据库 %s 未打开", dbInfos[i].FilePath)
+			continue
+		}
+
+		var afterSortSeq int64
+		if i == startIdx {
+			afterSortSeq = start.LastSortSeq
+		}
+
+		lastSortSeq, err := ds.scanMessages(ctx, db, tableName, req.Talker, req.StartTime, req.EndTime, afterSortSeq, limit-len(messages),
+			func(msg *model.Message) bool {
+				messages = append(messages, msg)
+				return len(messages) < limit
+			})
+		if err != nil {
+			return nil, "", err
+		}
+
+		if len(messages) >= limit {
+			next := Cursor{FilePath: dbInfos[i].FilePath, StartTime: dbInfos[i].StartTime.Unix(), LastSortSeq: lastSortSeq}
+			return messages, next.String(), nil
+		}
+	}
+
+	return messages, "", nil
+}
+
+// scanMessages 是 StreamMessages/ListMessages 共用的单分片扫描逻辑：按 sort_seq
+// 升序读取 afterSortSeq 之后的消息，依次交给 yield；yield 返回 false 时提前停止
+// 扫描。limit<=0 表示不在 SQL 层限制条数。返回本次扫描到的最后一条 sort_seq。
+func (ds *DataSource) scanMessages(ctx context.Context, db *sql.DB, tableName, talker string, startTime, endTime time.Time, afterSortSeq int64, limit int, yield func(*model.Message) bool) (int64, error) {
+	query := fmt.Sprintf(`
+		SELECT m.sort_seq, m.local_type, n.user_name, m.create_time, m.message_content, m.packed_info_data, m.status
+		FROM %s m
+		LEFT JOIN Name2Id n ON m.real_sender_id = n.rowid
+		WHERE m.create_time >= ? AND m.create_time <= ? AND m.sort_seq > ?
+		ORDER BY m.sort_seq ASC
+	`, tableName)
+	args := []interface{}{startTime.Unix(), endTime.Unix(), afterSortSeq}
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		if strings.Contains(err.Error(), "no such table") {
+			return afterSortSeq, nil
+		}
+		return afterSortSeq, errors.QueryFailed(query, err)
+	}
+	defer rows.Close()
+
+	lastSortSeq := afterSortSeq
+	for rows.Next() {
+		if err := ctx.Err(); err != nil {
+			return lastSortSeq, err
+		}
+
+		var msg model.MessageV4
+		err := rows.Scan(
+			&msg.SortSeq,
+			&msg.LocalType,
+			&msg.UserName,
+			&msg.CreateTime,
+			&msg.MessageContent,
+			&msg.PackedInfoData,
+			&msg.Status,
+		)
+		if err != nil {
+			return lastSortSeq, errors.ScanRowFailed(err)
+		}
+
+		wrapped := msg.Wrap(talker)
+		lastSortSeq = wrapped.Seq
+
+		if !yield(wrapped) {
+			break
+		}
+	}
+
+	return lastSortSeq, rows.Err()
+}
+
+// Export 把 StreamMessages 的结果以指定格式写出，不把完整结果集放进内存，适合
+// 喂给向量库或 LLM 微调数据集等下游管道
+func (ds *DataSource) Export(ctx context.Context, req MessagesRequest, format string, w io.Writer) error {
+	msgCh, errCh := ds.StreamMessages(ctx, req)
+
+	var err error
+	switch format {
+	case ExportFormatJSONL:
+		err = exportJSONL(msgCh, w)
+	case ExportFormatCSV:
+		err = exportCSV(msgCh, w)
+	case ExportFormatMarkdown:
+		err = exportMarkdown(msgCh, w)
+	default:
+		err = errors.ExportFormatUnsupported(format)
+	}
+
+	if err != nil {
+		// 写出提前失败（或格式不支持）时 msgCh 可能还有尚未消费的数据，
+		// StreamMessages 的生产者协程会一直阻塞在 msgCh<- 上；必须排空它才能让
+		// 协程退出，否则每次写出失败都会泄漏一个协程
+		for range msgCh {
+		}
+		<-errCh
+		return err
+	}
+
+	if streamErr := <-errCh; streamErr != nil {
+		return streamErr
+	}
+
+	return nil
+}
+
+func exportJSONL(msgCh <-chan *model.Message, w io.Writer) error {
+	enc := json.NewEncoder(w)
+	for msg := range msgCh {
+		if err := enc.Encode(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func exportCSV(msgCh <-chan *model.Message, w io.Writer) error {
+	cw := csv.NewWriter(w)
+
+	if err := cw.Write([]string{"seq", "time", "sender", "type", "content"}); err != nil {
+		return err
+	}
+
+	for msg := range msgCh {
+		record := []string{
+			strconv.FormatInt(msg.Seq, 10),
+			msg.Time.Format(time.RFC3339),
+			msg.Sender,
+			strconv.Itoa(msg.Type),
+			msg.PlainText(),
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func exportMarkdown(msgCh <-chan *model.Message, w io.Writer) error {
+	for msg := range msgCh {
+		_, err := fmt.Fprintf(w, "**%s** _(%s)_\n\n%s\n\n---\n\n",
+			msg.Sender, msg.Time.Format(time.RFC3339), msg.PlainText())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
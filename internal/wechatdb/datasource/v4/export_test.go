@@ -0,0 +1,269 @@
+package v4
+
+import (
+	"context"
+	"crypto/md5"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	c := Cursor{FilePath: "/data/message_2.db", StartTime: 1700000000, LastSortSeq: 12345}
+	parsed, err := ParseCursor(c.String())
+	if err != nil {
+		t.Fatalf("ParseCursor 失败: %v", err)
+	}
+	if parsed != c {
+		t.Fatalf("游标往返后不一致: got %+v want %+v", parsed, c)
+	}
+}
+
+// TestCursorRoundTripWindowsPath 验证 FilePath 带冒号（Windows 盘符）时游标仍
+// 然能正确往返，不会被 String()/ParseCursor 的分隔符拆散
+func TestCursorRoundTripWindowsPath(t *testing.T) {
+	c := Cursor{FilePath: `C:\Users\test\message_1.db`, StartTime: 1700000000, LastSortSeq: 1}
+	parsed, err := ParseCursor(c.String())
+	if err != nil {
+		t.Fatalf("ParseCursor 失败: %v", err)
+	}
+	if parsed != c {
+		t.Fatalf("游标往返后不一致: got %+v want %+v", parsed, c)
+	}
+}
+
+func TestParseCursorEmpty(t *testing.T) {
+	c, err := ParseCursor("")
+	if err != nil {
+		t.Fatalf("空字符串应该解析成零值游标，实际报错 %v", err)
+	}
+	if c != (Cursor{}) {
+		t.Fatalf("空字符串应该解析成零值游标，实际 %+v", c)
+	}
+}
+
+func TestParseCursorInvalid(t *testing.T) {
+	if _, err := ParseCursor("not-a-cursor"); err == nil {
+		t.Fatalf("格式不对的游标应该报错")
+	}
+}
+
+// TestListMessagesCursorSurvivesShardInsertion 验证游标按 FilePath/StartTime
+// 定位分片：两次 ListMessages 调用之间，热重载在游标指向的分片之前插入了一个新
+// 分片（getDBInfosForTimeRange 返回的 slice 下标因此整体后移一位），分页结果也
+// 不应该跳过或重复消息 —— 回归：此前的 ShardIndex 是纯粹的位置下标，这种情况下
+// 会错误地从新插入的分片继续读
+func TestListMessagesCursorSurvivesShardInsertion(t *testing.T) {
+	db1, _, path1 := newTestExportShardDB(t, "alice", 1)
+	defer db1.Close()
+	db2, _, path2 := newTestExportShardDB(t, "alice", 1)
+	defer db2.Close()
+
+	shard1 := MessageDBInfo{FilePath: path1, StartTime: time.Now().Add(-2 * time.Hour), EndTime: time.Now().Add(-time.Hour)}
+	shard2 := MessageDBInfo{FilePath: path2, StartTime: time.Now().Add(-time.Hour), EndTime: time.Now().Add(time.Hour)}
+
+	ds := &DataSource{
+		messageDbs:   map[string]*sql.DB{path1: db1, path2: db2},
+		messageFiles: []MessageDBInfo{shard1, shard2},
+	}
+
+	req := MessagesRequest{StartTime: shard1.StartTime, EndTime: shard2.EndTime, Talker: "alice"}
+
+	msgs, cursor, err := ds.ListMessages(context.Background(), req, "", 1)
+	if err != nil {
+		t.Fatalf("ListMessages 第一页失败: %v", err)
+	}
+	if len(msgs) != 1 || cursor == "" {
+		t.Fatalf("期望拿到 shard1 的 1 条消息并返回下一页游标，实际 %d 条，游标 %q", len(msgs), cursor)
+	}
+
+	// 模拟热重载：在 shard1 之前插入一个更老的分片，shard1/shard2 在
+	// getDBInfosForTimeRange 新算出的 slice 里的下标都往后移了一位
+	db0, _, path0 := newTestExportShardDB(t, "alice", 1)
+	defer db0.Close()
+	shard0 := MessageDBInfo{FilePath: path0, StartTime: time.Now().Add(-3 * time.Hour), EndTime: shard1.StartTime}
+	ds.mu.Lock()
+	ds.messageDbs[path0] = db0
+	ds.messageFiles = []MessageDBInfo{shard0, shard1, shard2}
+	ds.mu.Unlock()
+
+	req.StartTime = shard0.StartTime
+	msgs, _, err = ds.ListMessages(context.Background(), req, cursor, 10)
+	if err != nil {
+		t.Fatalf("ListMessages 第二页失败: %v", err)
+	}
+	if len(msgs) != 1 {
+		t.Fatalf("游标应该还是指向 shard2 的剩余消息，期望 1 条，实际 %d 条", len(msgs))
+	}
+}
+
+// TestListMessagesRejectsCursorForRemovedShard 验证游标指向的分片在两次调用之
+// 间被热重载移除后，ListMessages 会返回 CursorInvalid，而不是静默地从头开始或
+// 者指向一个无关的分片
+func TestListMessagesRejectsCursorForRemovedShard(t *testing.T) {
+	db1, _, path1 := newTestExportShardDB(t, "alice", 1)
+	defer db1.Close()
+	db2, _, path2 := newTestExportShardDB(t, "alice", 1)
+	defer db2.Close()
+
+	shard1 := MessageDBInfo{FilePath: path1, StartTime: time.Now().Add(-2 * time.Hour), EndTime: time.Now().Add(-time.Hour)}
+	shard2 := MessageDBInfo{FilePath: path2, StartTime: time.Now().Add(-time.Hour), EndTime: time.Now().Add(time.Hour)}
+	ds := &DataSource{
+		messageDbs:   map[string]*sql.DB{path1: db1, path2: db2},
+		messageFiles: []MessageDBInfo{shard1, shard2},
+	}
+
+	cursor := Cursor{FilePath: path1, StartTime: shard1.StartTime.Unix(), LastSortSeq: 0}.String()
+
+	// 模拟热重载移除了 shard1，只剩下 shard2
+	ds.mu.Lock()
+	delete(ds.messageDbs, path1)
+	ds.messageFiles = []MessageDBInfo{shard2}
+	ds.mu.Unlock()
+
+	req := MessagesRequest{StartTime: shard1.StartTime, EndTime: shard2.EndTime, Talker: "alice"}
+	if _, _, err := ds.ListMessages(context.Background(), req, cursor, 10); err == nil {
+		t.Fatalf("游标指向的分片已被移除，期望 ListMessages 返回错误")
+	}
+}
+
+// failingWriter 模拟导出过程中途写失败
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+// newTestExportShardDB 建一个带有 n 条消息的分片。n 超过 msgCh 缓冲区容量（64）
+// 时，StreamMessages 的生产者协程会在 msgCh 写满后阻塞，这样才能验证 Export 出错
+// 时是否真的排空了 msgCh
+func newTestExportShardDB(t *testing.T, talker string, n int) (*sql.DB, string, string) {
+	t.Helper()
+
+	sum := md5.Sum([]byte(talker))
+	tableName := "Msg_" + hex.EncodeToString(sum[:])
+
+	path := filepath.Join(t.TempDir(), "message_0.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("打开临时数据库失败: %v", err)
+	}
+
+	createSQL := fmt.Sprintf(`CREATE TABLE %s (
+		sort_seq INTEGER PRIMARY KEY,
+		local_type INTEGER,
+		real_sender_id INTEGER,
+		create_time INTEGER,
+		message_content TEXT,
+		packed_info_data BLOB,
+		status INTEGER
+	)`, tableName)
+	if _, err := db.Exec(createSQL); err != nil {
+		t.Fatalf("建表失败: %v", err)
+	}
+
+	// scanMessages 会 LEFT JOIN Name2Id 取发送者，缺了这张表查询会被当成空分片；
+	// 消息的 real_sender_id 是 0，对应这里 rowid 为 0 的一行
+	if _, err := db.Exec(`CREATE TABLE Name2Id (user_name TEXT)`); err != nil {
+		t.Fatalf("建 Name2Id 表失败: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO Name2Id (rowid, user_name) VALUES (0, ?)`, talker); err != nil {
+		t.Fatalf("写入 Name2Id 失败: %v", err)
+	}
+
+	now := time.Now().Unix()
+	for i := 0; i < n; i++ {
+		_, err := db.Exec(fmt.Sprintf(
+			`INSERT INTO %s (sort_seq, local_type, real_sender_id, create_time, message_content, status) VALUES (?, 1, 0, ?, 'hi', 1)`,
+			tableName), i+1, now)
+		if err != nil {
+			t.Fatalf("插入消息失败: %v", err)
+		}
+	}
+
+	return db, tableName, path
+}
+
+// TestExportDrainsChannelOnWriteFailure 验证写出函数中途失败时，Export 会排空
+// msgCh 并消费 errCh，StreamMessages 的生产者协程不会永远阻塞在 msgCh<- 上 ——
+// 回归 chunk0-5 的 review：此前只有 unsupported-format 分支会排空
+func TestExportDrainsChannelOnWriteFailure(t *testing.T) {
+	// 故意超过 StreamMessages 里 msgCh 的缓冲区容量（64），逼生产者协程在消费者
+	// 提前退出时阻塞住，泄漏与否才能被观察到
+	db, _, path := newTestExportShardDB(t, "alice", 200)
+	defer db.Close()
+
+	ds := &DataSource{
+		messageDbs: map[string]*sql.DB{path: db},
+		messageFiles: []MessageDBInfo{{
+			FilePath:  path,
+			StartTime: time.Now().Add(-time.Hour),
+			EndTime:   time.Now().Add(time.Hour),
+		}},
+	}
+
+	req := MessagesRequest{
+		StartTime: time.Now().Add(-time.Hour),
+		EndTime:   time.Now().Add(time.Hour),
+		Talker:    "alice",
+	}
+
+	runtime.Gosched()
+	before := runtime.NumGoroutine()
+
+	if err := ds.Export(context.Background(), req, ExportFormatJSONL, failingWriter{}); err == nil {
+		t.Fatalf("期望写出失败时 Export 返回错误")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Fatalf("StreamMessages 的生产者协程疑似泄漏：NumGoroutine before=%d after=%d", before, after)
+	}
+}
+
+// TestExportUnsupportedFormatDrainsChannel 验证不支持的导出格式同样会排空
+// msgCh，和写出失败分支保持一致的协程清理行为
+func TestExportUnsupportedFormatDrainsChannel(t *testing.T) {
+	db, _, path := newTestExportShardDB(t, "alice", 200)
+	defer db.Close()
+
+	ds := &DataSource{
+		messageDbs: map[string]*sql.DB{path: db},
+		messageFiles: []MessageDBInfo{{
+			FilePath:  path,
+			StartTime: time.Now().Add(-time.Hour),
+			EndTime:   time.Now().Add(time.Hour),
+		}},
+	}
+
+	req := MessagesRequest{
+		StartTime: time.Now().Add(-time.Hour),
+		EndTime:   time.Now().Add(time.Hour),
+		Talker:    "alice",
+	}
+
+	runtime.Gosched()
+	before := runtime.NumGoroutine()
+
+	if err := ds.Export(context.Background(), req, "unknown-format", failingWriter{}); err == nil {
+		t.Fatalf("期望不支持的格式返回错误")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Fatalf("StreamMessages 的生产者协程疑似泄漏：NumGoroutine before=%d after=%d", before, after)
+	}
+}
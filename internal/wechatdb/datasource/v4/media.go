@@ -0,0 +1,181 @@
+package v4
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/model"
+)
+
+// MediaResolver 定义一种媒体文件的查找与读取方式。DataSource 按配置顺序持有一
+// 组 MediaResolver（本地硬链接优先，远程对象存储兜底），GetMedia 依次尝试直到
+// 第一个命中
+type MediaResolver interface {
+	// Name 用于日志、调试，也是 OpenMedia 用来把 Media 派发回正确解析器的标识，
+	// 必须和 Media.Source 的取值保持一致
+	Name() string
+	// Lookup 根据 md5 或文件名前缀查找媒体文件，未命中时返回 errors.ErrMediaNotFound；
+	// 命中时必须把 Media.Source 置为自己的 Name()，OpenMedia 按这个字段直接派发
+	// 给对应解析器，不再靠 Open 返回错误来试探
+	Lookup(ctx context.Context, _type, key string) (*model.Media, error)
+	// Open 打开 Lookup 返回的 Media 对应的数据流。Media.Source 未知（例如旧版本
+	// 缓存下来的 Media）时，OpenMedia 会退化为依次尝试，此时如果这个 Media 不是
+	// 由自己解析出来的，返回 errors.ErrMediaResolverSkip 交给链上下一个解析器
+	Open(ctx context.Context, media *model.Media) (io.ReadCloser, error)
+}
+
+// MediaConfig 描述远程对象存储的连接信息，本地硬链接查找失败时 GetMedia 会
+// fallback 到按此配置构建的 MediaResolver
+type MediaConfig struct {
+	// Backend 选择远程后端："s3"（含 MinIO 等 S3 兼容服务）、"oss"、"cos"；留空表示
+	// 不启用远程解析，只走本地硬链接
+	Backend string
+
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	AccessKeySecret string
+	// SessionToken 用于 STS 临时凭证场景，可留空
+	SessionToken string
+	// Prefix 是对象 key 的前缀，例如 "wechat/media/"
+	Prefix string
+	// UseSSL 控制 s3 后端是否使用 HTTPS 访问 Endpoint，零值 false 即使用 HTTP；
+	// oss/cos 后端不读取这个字段
+	UseSSL bool
+	// SignedURLExpiry 是签名 URL 的有效期，默认 15 分钟
+	SignedURLExpiry time.Duration
+}
+
+// WithMediaConfig 为 DataSource 配置远程媒体解析后端（S3/MinIO、阿里云 OSS、腾讯云 COS）
+func WithMediaConfig(cfg MediaConfig) Option {
+	return func(o *options) {
+		o.mediaConfig = &cfg
+	}
+}
+
+// initMediaResolvers 组装 GetMedia/OpenMedia 使用的解析链：本地硬链接解析器
+// 始终排第一位，配置了 MediaConfig 时追加对应的远程解析器兜底
+func (ds *DataSource) initMediaResolvers(path string, cfg *MediaConfig) error {
+	ds.mediaResolvers = []MediaResolver{
+		&localHardlinkResolver{db: ds.mediaDb, basePath: path},
+	}
+
+	if cfg == nil || cfg.Backend == "" {
+		return nil
+	}
+
+	remote, err := newRemoteMediaResolver(*cfg)
+	if err != nil {
+		return err
+	}
+	ds.mediaResolvers = append(ds.mediaResolvers, remote)
+
+	return nil
+}
+
+// localHardlinkResolver 是原先 GetMedia 直接查询 hardlink.db 的逻辑，现在作为
+// 解析链的第一环
+type localHardlinkResolver struct {
+	db       *sql.DB
+	basePath string
+}
+
+func (r *localHardlinkResolver) Name() string { return "local-hardlink" }
+
+func (r *localHardlinkResolver) Lookup(ctx context.Context, _type, key string) (*model.Media, error) {
+	var table string
+	switch _type {
+	case "image":
+		table = "image_hardlink_info_v3"
+	case "video":
+		table = "video_hardlink_info_v3"
+	case "file":
+		table = "file_hardlink_info_v3"
+	default:
+		return nil, errors.MediaTypeUnsupported(_type)
+	}
+
+	query := fmt.Sprintf(`
+	SELECT
+		f.md5,
+		f.file_name,
+		f.file_size,
+		f.modify_time,
+		IFNULL(d1.username,""),
+		IFNULL(d2.username,"")
+	FROM
+		%s f
+	LEFT JOIN
+		dir2id d1 ON d1.rowid = f.dir1
+	LEFT JOIN
+		dir2id d2 ON d2.rowid = f.dir2
+	`, table)
+	query += " WHERE f.md5 = ? OR f.file_name LIKE ? || '%'"
+	args := []interface{}{key, key}
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.QueryFailed(query, err)
+	}
+	defer rows.Close()
+
+	var media *model.Media
+	for rows.Next() {
+		var mediaV4 model.MediaV4
+		err := rows.Scan(
+			&mediaV4.Key,
+			&mediaV4.Name,
+			&mediaV4.Size,
+			&mediaV4.ModifyTime,
+			&mediaV4.Dir1,
+			&mediaV4.Dir2,
+		)
+		if err != nil {
+			return nil, errors.ScanRowFailed(err)
+		}
+		mediaV4.Type = _type
+		media = mediaV4.Wrap()
+
+		// 跳过缩略图
+		if _type == "image" && !strings.Contains(media.Name, "_t") {
+			break
+		}
+	}
+
+	if media == nil {
+		return nil, errors.ErrMediaNotFound
+	}
+
+	media.Path = filepath.Join(r.basePath, media.Dir1, media.Dir2, media.Name)
+	media.Source = r.Name()
+
+	return media, nil
+}
+
+func (r *localHardlinkResolver) Open(ctx context.Context, media *model.Media) (io.ReadCloser, error) {
+	if media.Source != "" && media.Source != r.Name() {
+		return nil, errors.ErrMediaResolverSkip
+	}
+
+	if media.Path == "" {
+		return nil, errors.ErrMediaResolverSkip
+	}
+
+	f, err := os.Open(media.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errors.ErrMediaResolverSkip
+		}
+		return nil, err
+	}
+
+	return f, nil
+}
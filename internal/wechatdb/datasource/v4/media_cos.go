@@ -0,0 +1,80 @@
+package v4
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/tencentyun/cos-go-sdk-v5"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/model"
+)
+
+// cosMediaResolver 通过腾讯云 COS 解析媒体文件
+type cosMediaResolver struct {
+	client *cos.Client
+	cfg    MediaConfig
+}
+
+func newCOSMediaResolver(cfg MediaConfig) (MediaResolver, error) {
+	baseURL, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, errors.MediaBackendInitFailed("cos", err)
+	}
+
+	client := cos.NewClient(&cos.BaseURL{BucketURL: baseURL}, &http.Client{
+		Transport: &cos.AuthorizationTransport{
+			SecretID:     cfg.AccessKeyID,
+			SecretKey:    cfg.AccessKeySecret,
+			SessionToken: cfg.SessionToken,
+		},
+	})
+
+	return &cosMediaResolver{client: client, cfg: cfg}, nil
+}
+
+func (r *cosMediaResolver) Name() string { return "cos" }
+
+func (r *cosMediaResolver) Lookup(ctx context.Context, _type, key string) (*model.Media, error) {
+	objKey := objectKey(r.cfg, key)
+
+	resp, err := r.client.Object.Head(ctx, objKey, nil)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil, errors.ErrMediaNotFound
+		}
+		return nil, errors.MediaBackendRequestFailed("cos", err)
+	}
+
+	signedURL, err := r.client.Object.GetPresignedURL(ctx, http.MethodGet, objKey,
+		r.cfg.AccessKeyID, r.cfg.AccessKeySecret, r.cfg.SignedURLExpiry, nil)
+	if err != nil {
+		return nil, errors.MediaBackendRequestFailed("cos", err)
+	}
+
+	return &model.Media{
+		Type:   _type,
+		Key:    key,
+		Name:   objKey,
+		Size:   resp.ContentLength,
+		Path:   signedURL.String(),
+		Source: r.Name(),
+	}, nil
+}
+
+func (r *cosMediaResolver) Open(ctx context.Context, media *model.Media) (io.ReadCloser, error) {
+	if media.Source != "" && media.Source != r.Name() {
+		return nil, errors.ErrMediaResolverSkip
+	}
+
+	resp, err := r.client.Object.Get(ctx, objectKey(r.cfg, media.Key), nil)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil, errors.ErrMediaResolverSkip
+		}
+		return nil, errors.MediaBackendRequestFailed("cos", err)
+	}
+	return resp.Body, nil
+}
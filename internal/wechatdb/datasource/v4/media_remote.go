@@ -0,0 +1,37 @@
+package v4
+
+import (
+	"time"
+
+	"github.com/sjzar/chatlog/internal/errors"
+)
+
+// defaultSignedURLExpiry 是 MediaConfig.SignedURLExpiry 未设置时的默认值
+const defaultSignedURLExpiry = 15 * time.Minute
+
+// newRemoteMediaResolver 根据 MediaConfig.Backend 构建对应的远程对象存储解析器，
+// mirrors 多后端抽象是聊天类后端（如 OpenIM）常见的存储抽象方式
+func newRemoteMediaResolver(cfg MediaConfig) (MediaResolver, error) {
+	if cfg.SignedURLExpiry <= 0 {
+		cfg.SignedURLExpiry = defaultSignedURLExpiry
+	}
+
+	switch cfg.Backend {
+	case "s3":
+		return newS3MediaResolver(cfg)
+	case "oss":
+		return newOSSMediaResolver(cfg)
+	case "cos":
+		return newCOSMediaResolver(cfg)
+	default:
+		return nil, errors.MediaBackendUnsupported(cfg.Backend)
+	}
+}
+
+// objectKey 计算远程对象的 key：Prefix 拼接 md5，镜像本地硬链接按 md5 寻址的方式
+func objectKey(cfg MediaConfig, md5 string) string {
+	if cfg.Prefix == "" {
+		return md5
+	}
+	return cfg.Prefix + md5
+}
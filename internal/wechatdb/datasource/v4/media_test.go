@@ -0,0 +1,98 @@
+package v4
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/model"
+)
+
+// fakeMediaResolver 是测试用的 MediaResolver，open 由每个用例自己定义，用来模拟
+// 某个解析器"认领"或"跳过"一个 Media
+type fakeMediaResolver struct {
+	name string
+	open func(media *model.Media) (io.ReadCloser, error)
+}
+
+func (f *fakeMediaResolver) Name() string { return f.name }
+
+func (f *fakeMediaResolver) Lookup(ctx context.Context, _type, key string) (*model.Media, error) {
+	return nil, errors.ErrMediaNotFound
+}
+
+func (f *fakeMediaResolver) Open(ctx context.Context, media *model.Media) (io.ReadCloser, error) {
+	return f.open(media)
+}
+
+// TestOpenMediaDispatchesBySource 验证 Media.Source 非空时，OpenMedia 直接派发
+// 给产出它的那个解析器，完全不调用链上其它解析器的 Open —— 回归 chunk0-2 的
+// review：不应该再靠 os.Open/os.IsNotExist 的副作用去猜测归属
+func TestOpenMediaDispatchesBySource(t *testing.T) {
+	local := &fakeMediaResolver{
+		name: "local-hardlink",
+		open: func(media *model.Media) (io.ReadCloser, error) {
+			t.Fatalf("Media.Source 已经指明是 s3，不应该尝试 local-hardlink")
+			return nil, nil
+		},
+	}
+	remote := &fakeMediaResolver{
+		name: "s3",
+		open: func(media *model.Media) (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader("remote-content")), nil
+		},
+	}
+
+	ds := &DataSource{mediaResolvers: []MediaResolver{local, remote}}
+
+	r, err := ds.OpenMedia(context.Background(), &model.Media{Key: "abc", Source: "s3"})
+	if err != nil {
+		t.Fatalf("OpenMedia 失败: %v", err)
+	}
+	defer r.Close()
+}
+
+// TestOpenMediaFallsBackWhenSourceUnknown 验证 Media.Source 为空（例如跨版本
+// 缓存下来的旧 Media）时，OpenMedia 退化为依次尝试，沿用 ErrMediaResolverSkip
+// 语义
+func TestOpenMediaFallsBackWhenSourceUnknown(t *testing.T) {
+	local := &fakeMediaResolver{
+		name: "local-hardlink",
+		open: func(media *model.Media) (io.ReadCloser, error) {
+			return nil, errors.ErrMediaResolverSkip
+		},
+	}
+	remote := &fakeMediaResolver{
+		name: "s3",
+		open: func(media *model.Media) (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader("remote-content")), nil
+		},
+	}
+
+	ds := &DataSource{mediaResolvers: []MediaResolver{local, remote}}
+
+	r, err := ds.OpenMedia(context.Background(), &model.Media{Key: "abc"})
+	if err != nil {
+		t.Fatalf("OpenMedia 失败: %v", err)
+	}
+	defer r.Close()
+}
+
+// TestOpenMediaNotFound 验证所有解析器都跳过时返回 ErrMediaNotFound
+func TestOpenMediaNotFound(t *testing.T) {
+	local := &fakeMediaResolver{
+		name: "local-hardlink",
+		open: func(media *model.Media) (io.ReadCloser, error) {
+			return nil, errors.ErrMediaResolverSkip
+		},
+	}
+
+	ds := &DataSource{mediaResolvers: []MediaResolver{local}}
+
+	_, err := ds.OpenMedia(context.Background(), &model.Media{Key: "abc"})
+	if err != errors.ErrMediaNotFound {
+		t.Fatalf("期望 errors.ErrMediaNotFound，实际 %v", err)
+	}
+}
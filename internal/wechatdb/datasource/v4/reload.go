@@ -0,0 +1,239 @@
+package v4
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/pkg/util"
+)
+
+// DataSourceOptions 控制 message_*.db 连接池参数与热重载扫描行为，通过
+// WithDataSourceOptions 传入 New
+type DataSourceOptions struct {
+	// BusyTimeout 对应 PRAGMA busy_timeout，SQLITE_BUSY 时的重试等待时间，默认 5s
+	BusyTimeout time.Duration
+	// MaxOpenConns 限制单个 message_*.db 的最大连接数，默认 4
+	MaxOpenConns int
+	// ConnMaxIdleTime 是空闲连接的回收时间，默认 5 分钟
+	ConnMaxIdleTime time.Duration
+	// WatchInterval 是周期性扫描新增/移除分片的兜底间隔，默认 30s；fsnotify 不可
+	// 用（或者对某些文件系统上的 rename 事件不敏感）时靠这个兜底
+	WatchInterval time.Duration
+	// ReadOnly 为 true 时对新打开的连接设置 PRAGMA query_only=1，避免 chatlog 自
+	// 己的只读查询意外写到 WeChat 正在使用的数据库
+	ReadOnly bool
+}
+
+func (o DataSourceOptions) withDefaults() DataSourceOptions {
+	if o.BusyTimeout <= 0 {
+		o.BusyTimeout = 5 * time.Second
+	}
+	if o.MaxOpenConns <= 0 {
+		o.MaxOpenConns = 4
+	}
+	if o.ConnMaxIdleTime <= 0 {
+		o.ConnMaxIdleTime = 5 * time.Minute
+	}
+	if o.WatchInterval <= 0 {
+		o.WatchInterval = 30 * time.Second
+	}
+	return o
+}
+
+// WithDataSourceOptions 配置 message_*.db 连接池参数与热重载扫描行为
+func WithDataSourceOptions(opts DataSourceOptions) Option {
+	return func(o *options) {
+		o.dsOptions = &opts
+	}
+}
+
+var messageFileRegexp = regexp.MustCompile(MessageFilePattern)
+
+// configureMessageDB 在 sql.Open 之后立即应用 PRAGMA 与连接池参数，避免并发的
+// MCP/HTTP 读请求在 WeChat 写入时撞上 SQLITE_BUSY
+func configureMessageDB(db *sql.DB, opts DataSourceOptions) error {
+	if _, err := db.Exec(fmt.Sprintf("PRAGMA busy_timeout=%d", opts.BusyTimeout.Milliseconds())); err != nil {
+		return errors.QueryFailed("PRAGMA busy_timeout", err)
+	}
+
+	// 只是探测一下当前 journal 模式，WAL 与否由 WeChat 客户端决定，这里不强制切换
+	var journalMode string
+	if err := db.QueryRow("PRAGMA journal_mode").Scan(&journalMode); err != nil {
+		return errors.QueryFailed("PRAGMA journal_mode", err)
+	}
+
+	if opts.ReadOnly {
+		if _, err := db.Exec("PRAGMA query_only=1"); err != nil {
+			return errors.QueryFailed("PRAGMA query_only", err)
+		}
+	}
+
+	db.SetMaxOpenConns(opts.MaxOpenConns)
+	db.SetConnMaxIdleTime(opts.ConnMaxIdleTime)
+
+	return nil
+}
+
+// watchMessageDbs 在后台协程里监听 path 下 message_*.db 的增删：WeChat 滚动新
+// 建分片时 fsnotify 负责实时感知，周期性扫描作为兜底。通过关闭 ds.stopWatch 退出。
+func (ds *DataSource) watchMessageDbs(path string) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Err(err).Msg("创建文件监听器失败，退化为纯周期性扫描")
+	} else if err := watcher.Add(path); err != nil {
+		log.Err(err).Msgf("监听目录 %s 失败，退化为纯周期性扫描", path)
+		watcher.Close()
+		watcher = nil
+	}
+	if watcher != nil {
+		defer watcher.Close()
+	}
+
+	ticker := time.NewTicker(ds.opts.WatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ds.stopWatch:
+			return
+		case <-ticker.C:
+			ds.rescanMessageDbs(path)
+		case event, ok := <-watcherEvents(watcher):
+			if !ok {
+				continue
+			}
+			if messageFileRegexp.MatchString(filepath.Base(event.Name)) {
+				ds.rescanMessageDbs(path)
+			}
+		}
+	}
+}
+
+// watcherEvents 在 watcher 为 nil 时返回 nil channel，select 在其上永远不会就
+// 绪，等价于只靠周期性扫描兜底
+func watcherEvents(w *fsnotify.Watcher) <-chan fsnotify.Event {
+	if w == nil {
+		return nil
+	}
+	return w.Events
+}
+
+// rescanMessageDbs 对比当前已打开的分片和磁盘上的 message_*.db，加载新出现的、
+// 卸载已消失的
+func (ds *DataSource) rescanMessageDbs(path string) {
+	files, err := util.FindFilesWithPatterns(path, MessageFilePattern, true)
+	if err != nil {
+		log.Err(err).Msg("扫描 message_*.db 失败")
+		return
+	}
+
+	ds.mu.RLock()
+	current := make(map[string]bool, len(ds.messageFiles))
+	for _, info := range ds.messageFiles {
+		current[info.FilePath] = true
+	}
+	ds.mu.RUnlock()
+
+	seen := make(map[string]bool, len(files))
+	for _, filePath := range files {
+		seen[filePath] = true
+		if !current[filePath] {
+			if err := ds.addMessageDB(filePath); err != nil {
+				log.Err(err).Msgf("加载新分片 %s 失败", filePath)
+			}
+		}
+	}
+
+	for filePath := range current {
+		if !seen[filePath] {
+			ds.removeMessageDB(filePath)
+		}
+	}
+}
+
+// addMessageDB 打开一个新出现的 message_*.db，读取它的 Timestamp 并在写锁下
+// 并入 messageFiles/messageDbs，同时重新计算相邻分片的 EndTime 边界
+func (ds *DataSource) addMessageDB(filePath string) error {
+	db, err := sql.Open("sqlite3", filePath)
+	if err != nil {
+		return errors.DBConnectFailed(filePath, err)
+	}
+
+	if err := configureMessageDB(db, ds.opts); err != nil {
+		db.Close()
+		return err
+	}
+
+	var timestamp int64
+	row := db.QueryRow("SELECT timestamp FROM Timestamp LIMIT 1")
+	if err := row.Scan(&timestamp); err != nil {
+		db.Close()
+		return errors.QueryFailed("SELECT timestamp FROM Timestamp", err)
+	}
+	startTime := time.Unix(timestamp, 0)
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	ds.messageFiles = append(ds.messageFiles, MessageDBInfo{
+		FilePath:  filePath,
+		StartTime: startTime,
+	})
+	recomputeMessageFileBoundaries(ds.messageFiles)
+	ds.messageDbs[filePath] = db
+
+	log.Info().Msgf("加载新分片 %s", filePath)
+
+	return nil
+}
+
+// recomputeMessageFileBoundaries 按 StartTime 排序并重新计算每个分片的
+// EndTime：每个分片的 EndTime 是下一个分片的 StartTime，最新的分片（不管它是
+// 刚加载进来的，还是因为更新的分片被移除而变成最新的）EndTime 延伸到
+// time.Now()。addMessageDB/removeMessageDB 共用这一套逻辑，保证分片集合发生
+// 任何变化后，时间范围查询都能立刻覆盖到当前时刻
+func recomputeMessageFileBoundaries(files []MessageDBInfo) {
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].StartTime.Before(files[j].StartTime)
+	})
+	for i := range files {
+		if i == len(files)-1 {
+			files[i].EndTime = time.Now()
+		} else {
+			files[i].EndTime = files[i+1].StartTime
+		}
+	}
+}
+
+// removeMessageDB 卸载一个已经从磁盘消失的分片（例如被用户手动清理）。剩下的
+// 分片要重新计算 EndTime 边界：如果被移除的正好是当前最新的分片，次新的分片
+// 需要接过 EndTime 延伸到 time.Now() 的职责，否则它会一直停留在旧的边界上，
+// 时间范围查询会错误地报 TimeRangeNotFound
+func (ds *DataSource) removeMessageDB(filePath string) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	if db, ok := ds.messageDbs[filePath]; ok {
+		db.Close()
+		delete(ds.messageDbs, filePath)
+	}
+
+	files := make([]MessageDBInfo, 0, len(ds.messageFiles))
+	for _, info := range ds.messageFiles {
+		if info.FilePath != filePath {
+			files = append(files, info)
+		}
+	}
+	recomputeMessageFileBoundaries(files)
+	ds.messageFiles = files
+
+	log.Info().Msgf("分片 %s 已移除", filePath)
+}
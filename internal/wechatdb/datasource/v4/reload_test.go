@@ -0,0 +1,154 @@
+package v4
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func newTestMessageDBFile(t *testing.T, dir, name string, timestamp int64) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("打开临时数据库失败: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(`CREATE TABLE Timestamp (timestamp INTEGER)`); err != nil {
+		t.Fatalf("建 Timestamp 表失败: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO Timestamp (timestamp) VALUES (?)`, timestamp); err != nil {
+		t.Fatalf("写入 timestamp 失败: %v", err)
+	}
+
+	return path
+}
+
+// TestConfigureMessageDBReadOnly 验证 ReadOnly 选项会落到 PRAGMA query_only=1 上
+func TestConfigureMessageDBReadOnly(t *testing.T) {
+	path := newTestMessageDBFile(t, t.TempDir(), "message_0.db", time.Now().Unix())
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("打开数据库失败: %v", err)
+	}
+	defer db.Close()
+
+	opts := DataSourceOptions{ReadOnly: true}.withDefaults()
+	if err := configureMessageDB(db, opts); err != nil {
+		t.Fatalf("configureMessageDB 失败: %v", err)
+	}
+
+	var queryOnly int
+	if err := db.QueryRow("PRAGMA query_only").Scan(&queryOnly); err != nil {
+		t.Fatalf("读取 PRAGMA query_only 失败: %v", err)
+	}
+	if queryOnly != 1 {
+		t.Fatalf("ReadOnly 选项应该打开 query_only，实际 %d", queryOnly)
+	}
+
+	if _, err := db.Exec(`CREATE TABLE t (a INTEGER)`); err == nil {
+		t.Fatalf("query_only=1 的连接不应该允许写入")
+	}
+}
+
+// TestAddAndRemoveMessageDB 验证热加载/卸载分片时 messageFiles/messageDbs 的状态
+// 在写锁下正确更新。这里卸载的是最老的分片，不会触发 EndTime 延伸到
+// time.Now() 的那条路径，参见 TestRemoveNewestMessageDBExtendsSurvivorEndTime
+func TestAddAndRemoveMessageDB(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+
+	older := newTestMessageDBFile(t, dir, "message_0.db", now.Add(-2*time.Hour).Unix())
+	newer := newTestMessageDBFile(t, dir, "message_1.db", now.Add(-time.Hour).Unix())
+
+	ds := &DataSource{
+		messageDbs:   make(map[string]*sql.DB),
+		messageFiles: make([]MessageDBInfo, 0),
+		opts:         DataSourceOptions{}.withDefaults(),
+	}
+
+	if err := ds.addMessageDB(older); err != nil {
+		t.Fatalf("加载 older 失败: %v", err)
+	}
+	if err := ds.addMessageDB(newer); err != nil {
+		t.Fatalf("加载 newer 失败: %v", err)
+	}
+
+	ds.mu.RLock()
+	if len(ds.messageFiles) != 2 {
+		ds.mu.RUnlock()
+		t.Fatalf("期望 2 个分片，实际 %d 个", len(ds.messageFiles))
+	}
+	if ds.messageFiles[0].FilePath != older {
+		ds.mu.RUnlock()
+		t.Fatalf("分片应该按 StartTime 升序排列，older 应该排第一个")
+	}
+	if !ds.messageFiles[0].EndTime.Equal(ds.messageFiles[1].StartTime) {
+		ds.mu.RUnlock()
+		t.Fatalf("older 的 EndTime 应该等于 newer 的 StartTime")
+	}
+	ds.mu.RUnlock()
+
+	ds.removeMessageDB(older)
+
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	if len(ds.messageFiles) != 1 {
+		t.Fatalf("卸载后期望剩 1 个分片，实际 %d 个", len(ds.messageFiles))
+	}
+	if _, ok := ds.messageDbs[older]; ok {
+		t.Fatalf("卸载后 older 的连接不应该还留在 messageDbs 里")
+	}
+	if _, ok := ds.messageDbs[newer]; !ok {
+		t.Fatalf("newer 的连接应该还在")
+	}
+}
+
+// TestRemoveNewestMessageDBExtendsSurvivorEndTime 验证移除当前最新的分片之后，
+// 次新的分片会接过 EndTime 延伸到 time.Now() 的职责，而不是停留在被移除分片的
+// StartTime 上 —— 回归：removeMessageDB 此前没有像 addMessageDB 那样重新计算
+// EndTime 边界，[被移除分片.StartTime, now) 这段时间范围会被 GetMessages/
+// SearchMessages/GetStats 错误地判定为 TimeRangeNotFound
+func TestRemoveNewestMessageDBExtendsSurvivorEndTime(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+
+	older := newTestMessageDBFile(t, dir, "message_0.db", now.Add(-2*time.Hour).Unix())
+	newer := newTestMessageDBFile(t, dir, "message_1.db", now.Add(-time.Hour).Unix())
+
+	ds := &DataSource{
+		messageDbs:   make(map[string]*sql.DB),
+		messageFiles: make([]MessageDBInfo, 0),
+		opts:         DataSourceOptions{}.withDefaults(),
+	}
+
+	if err := ds.addMessageDB(older); err != nil {
+		t.Fatalf("加载 older 失败: %v", err)
+	}
+	if err := ds.addMessageDB(newer); err != nil {
+		t.Fatalf("加载 newer 失败: %v", err)
+	}
+
+	// 移除当前最新的分片 newer，older 变成剩下分片里最新的一个
+	ds.removeMessageDB(newer)
+
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+
+	if len(ds.messageFiles) != 1 {
+		t.Fatalf("卸载后期望剩 1 个分片，实际 %d 个", len(ds.messageFiles))
+	}
+	survivor := ds.messageFiles[0]
+	if survivor.FilePath != older {
+		t.Fatalf("剩下的分片应该是 older")
+	}
+	if survivor.EndTime.Before(now) {
+		t.Fatalf("older 变成最新分片后，EndTime 应该延伸到 time.Now() 附近，实际还停留在 %s", survivor.EndTime)
+	}
+}
@@ -0,0 +1,281 @@
+package v4
+
+import (
+	"context"
+	"crypto/md5"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/model"
+)
+
+// ftsTableSuffix 是消息表关联的 FTS5 虚拟表的后缀命名
+const ftsTableSuffix = "_fts"
+
+// 重要：本文件的 ensureFTSIndex/searchMessagesInDB 依赖 SQLite 的 FTS5 扩展
+// （CREATE VIRTUAL TABLE ... USING fts5），而 mattn/go-sqlite3 默认不编译进
+// FTS5，必须在构建时加上 sqlite_fts5 这个 cgo 构建标签，否则运行期会报
+// "no such module: fts5"，SearchMessages 完全不可用：
+//
+//	go build -tags "sqlite_fts5" ./...
+//	go test  -tags "sqlite_fts5" ./...
+//
+// 引入 mattn/go-sqlite3 驱动的地方（本仓库是 datasource.go 里的
+// `_ "github.com/mattn/go-sqlite3"`）以及对应的 Makefile/CI 配置都需要同步带上
+// 这个标签。
+
+// SearchMessages 在指定时间范围内对某个 talker 的消息做全文检索，支持 FTS5 的
+// MATCH 语法（短语、前缀、AND/OR）。查询会并发地在 getDBInfosForTimeRange 选出
+// 的每个分片上执行，再按 sort_seq 合并排序。
+func (ds *DataSource) SearchMessages(ctx context.Context, query string, talker string, startTime, endTime time.Time, limit, offset int) ([]*model.Message, error) {
+	if query == "" {
+		return nil, errors.ErrQueryEmpty
+	}
+	if talker == "" {
+		return nil, errors.ErrTalkerEmpty
+	}
+
+	dbInfos := ds.getDBInfosForTimeRange(startTime, endTime)
+	if len(dbInfos) == 0 {
+		return nil, errors.TimeRangeNotFound(startTime, endTime)
+	}
+
+	_talkerMd5Bytes := md5.Sum([]byte(talker))
+	talkerMd5 := hex.EncodeToString(_talkerMd5Bytes[:])
+	tableName := "Msg_" + talkerMd5
+
+	// 并发查询各分片，结果按下标写回，互不阻塞
+	results := make([][]*model.Message, len(dbInfos))
+	var wg sync.WaitGroup
+
+	for i, dbInfo := range dbInfos {
+		wg.Add(1)
+		go func(i int, dbInfo MessageDBInfo) {
+			defer wg.Done()
+
+			db, ok := ds.getMessageDB(dbInfo.FilePath)
+			if !ok {
+				log.Error().Msgf("数据库 %s 未打开", dbInfo.FilePath)
+				return
+			}
+
+			msgs, err := ds.searchMessagesInDB(ctx, db, dbInfo.FilePath, tableName, query, talker)
+			if err != nil {
+				log.Err(err).Msgf("在数据库 %s 中检索消息失败", dbInfo.FilePath)
+				return
+			}
+
+			results[i] = msgs
+		}(i, dbInfo)
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	totalMessages := make([]*model.Message, 0)
+	for _, msgs := range results {
+		for _, msg := range msgs {
+			// 分片只按时间范围粗选，这里按 create_time 精确过滤，两端都包含，和 GetMessages 一致
+			if msg.Time.Unix() < startTime.Unix() || msg.Time.Unix() > endTime.Unix() {
+				continue
+			}
+			totalMessages = append(totalMessages, msg)
+		}
+	}
+
+	// 对所有消息按时间排序
+	sort.Slice(totalMessages, func(i, j int) bool {
+		return totalMessages[i].Seq < totalMessages[j].Seq
+	})
+
+	// 处理分页
+	if limit > 0 {
+		if offset >= len(totalMessages) {
+			return []*model.Message{}, nil
+		}
+		end := offset + limit
+		if end > len(totalMessages) {
+			end = len(totalMessages)
+		}
+		return totalMessages[offset:end], nil
+	}
+
+	return totalMessages, nil
+}
+
+// searchMessagesInDB 在单个分片数据库中执行 FTS5 检索
+func (ds *DataSource) searchMessagesInDB(ctx context.Context, db *sql.DB, filePath, tableName, query, talker string) ([]*model.Message, error) {
+	// 检查表是否存在
+	var exists bool
+	err := db.QueryRowContext(ctx,
+		"SELECT 1 FROM sqlite_master WHERE type='table' AND name=?", tableName).Scan(&exists)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return []*model.Message{}, nil
+		}
+		return nil, errors.QueryFailed("", err)
+	}
+
+	ftsTable, err := ds.ensureFTSIndex(ctx, db, filePath, tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	sqlQuery := fmt.Sprintf(`
+		SELECT m.sort_seq, m.local_type, n.user_name, m.create_time, m.message_content, m.packed_info_data, m.status,
+			snippet(%[1]s, 0, '[', ']', '...', 8)
+		FROM %[1]s f
+		JOIN %[2]s m ON f.rowid = m.sort_seq
+		LEFT JOIN Name2Id n ON m.real_sender_id = n.rowid
+		WHERE f MATCH ?
+		ORDER BY m.sort_seq ASC
+	`, ftsTable, tableName)
+
+	rows, err := db.QueryContext(ctx, sqlQuery, query)
+	if err != nil {
+		return nil, errors.QueryFailed(sqlQuery, err)
+	}
+	defer rows.Close()
+
+	messages := []*model.Message{}
+	for rows.Next() {
+		var msg model.MessageV4
+		var snippet string
+		err := rows.Scan(
+			&msg.SortSeq,
+			&msg.LocalType,
+			&msg.UserName,
+			&msg.CreateTime,
+			&msg.MessageContent,
+			&msg.PackedInfoData,
+			&msg.Status,
+			&snippet,
+		)
+		if err != nil {
+			return nil, errors.ScanRowFailed(err)
+		}
+
+		wrapped := msg.Wrap(talker)
+		wrapped.Snippet = snippet
+		messages = append(messages, wrapped)
+	}
+
+	return messages, nil
+}
+
+// ftsTriggersKey 组合分片路径和虚拟表名，作为 ftsTriggersOK 的 key。同一个
+// talker 在每个 message_N.db 分片里都有一份物理上独立的 Msg_<md5> / Msg_<md5>_fts
+// 表，触发器是否装得上因分片的可写性而异，不能只用 ftsTable 名字做 key，否则会
+// 把某一个分片探测到的结果错误地套用到所有同名分片上
+func ftsTriggersKey(filePath, ftsTable string) string {
+	return filePath + "|" + ftsTable
+}
+
+// ensureFTSIndex 保证 tableName 对应的 FTS5 虚拟表存在且数据是最新的，返回虚拟
+// 表名。虚拟表首次使用时通过 INSERT ... SELECT 懒加载构建；若数据库可写，则额
+// 外挂上触发器保持同步，否则退化为每次查询前按需 rebuild。DataSourceOptions.ReadOnly
+// 为 true 时（连接已经是 PRAGMA query_only=1）不会尝试建表/建触发器，虚拟表不
+// 存在就直接报错，而不是先撞一次 CREATE VIRTUAL TABLE 失败再兜底。
+func (ds *DataSource) ensureFTSIndex(ctx context.Context, db *sql.DB, filePath, tableName string) (string, error) {
+	ftsTable := tableName + ftsTableSuffix
+	triggersKey := ftsTriggersKey(filePath, ftsTable)
+
+	var exists bool
+	err := db.QueryRowContext(ctx,
+		"SELECT 1 FROM sqlite_master WHERE type='table' AND name=?", ftsTable).Scan(&exists)
+	if err != nil && err != sql.ErrNoRows {
+		return "", errors.QueryFailed("", err)
+	}
+
+	if err == sql.ErrNoRows {
+		if ds.opts.ReadOnly {
+			ds.ftsMu.Lock()
+			ds.ftsTriggersOK[triggersKey] = false
+			ds.ftsMu.Unlock()
+			return "", errors.ErrFTSIndexUnavailableReadOnly
+		}
+
+		createSQL := fmt.Sprintf(`
+			CREATE VIRTUAL TABLE IF NOT EXISTS %s USING fts5(
+				message_content, user_name,
+				content='%s', content_rowid='sort_seq'
+			)
+		`, ftsTable, tableName)
+		if _, err := db.ExecContext(ctx, createSQL); err != nil {
+			return "", errors.QueryFailed(createSQL, err)
+		}
+
+		populateSQL := fmt.Sprintf(`
+			INSERT INTO %s(rowid, message_content, user_name)
+			SELECT m.sort_seq, m.message_content, IFNULL(n.user_name, '')
+			FROM %s m
+			LEFT JOIN Name2Id n ON m.real_sender_id = n.rowid
+		`, ftsTable, tableName)
+		if _, err := db.ExecContext(ctx, populateSQL); err != nil {
+			return "", errors.QueryFailed(populateSQL, err)
+		}
+
+		ds.ftsMu.Lock()
+		_, tried := ds.ftsTriggersOK[triggersKey]
+		ds.ftsMu.Unlock()
+		if !tried {
+			ds.setupFTSTriggers(ctx, db, filePath, tableName, ftsTable)
+		}
+		return ftsTable, nil
+	}
+
+	// 虚拟表已存在；如果本次打开没能挂上触发器（多半是只读数据库），每次检索
+	// 前做一次全量 rebuild，保证结果不会漏掉最新写入的消息。ReadOnly 模式下
+	// rebuild 本身也是一次写操作，会被 query_only=1 拒绝，干脆跳过，检索结果
+	// 可能漏掉连接建立之后的新消息，但总比每次查询都报错好
+	ds.ftsMu.Lock()
+	writable := ds.ftsTriggersOK[triggersKey]
+	ds.ftsMu.Unlock()
+	if !writable && !ds.opts.ReadOnly {
+		rebuildSQL := fmt.Sprintf(`INSERT INTO %[1]s(%[1]s) VALUES('rebuild')`, ftsTable)
+		if _, err := db.ExecContext(ctx, rebuildSQL); err != nil {
+			log.Debug().Err(err).Msgf("重建 FTS 索引 %s 失败", ftsTable)
+		}
+	}
+
+	return ftsTable, nil
+}
+
+// setupFTSTriggers 尝试在 Msg_<md5> 表上挂载触发器，使 FTS 索引随写入自动更新。
+// 数据库只读时建表会失败，记录下来以便 ensureFTSIndex 改走按需 rebuild 的路径。
+func (ds *DataSource) setupFTSTriggers(ctx context.Context, db *sql.DB, filePath, tableName, ftsTable string) {
+	triggers := []string{
+		fmt.Sprintf(`CREATE TRIGGER IF NOT EXISTS %[1]s_ai AFTER INSERT ON %[2]s BEGIN
+			INSERT INTO %[1]s(rowid, message_content, user_name) VALUES (new.sort_seq, new.message_content, '');
+		END`, ftsTable, tableName),
+		fmt.Sprintf(`CREATE TRIGGER IF NOT EXISTS %[1]s_ad AFTER DELETE ON %[2]s BEGIN
+			INSERT INTO %[1]s(%[1]s, rowid, message_content, user_name) VALUES('delete', old.sort_seq, old.message_content, '');
+		END`, ftsTable, tableName),
+		fmt.Sprintf(`CREATE TRIGGER IF NOT EXISTS %[1]s_au AFTER UPDATE ON %[2]s BEGIN
+			INSERT INTO %[1]s(%[1]s, rowid, message_content, user_name) VALUES('delete', old.sort_seq, old.message_content, '');
+			INSERT INTO %[1]s(rowid, message_content, user_name) VALUES (new.sort_seq, new.message_content, '');
+		END`, ftsTable, tableName),
+	}
+
+	ok := true
+	for _, stmt := range triggers {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			log.Debug().Err(err).Msgf("数据库 %s 只读，跳过 FTS 触发器维护", tableName)
+			ok = false
+			break
+		}
+	}
+
+	ds.ftsMu.Lock()
+	ds.ftsTriggersOK[ftsTriggersKey(filePath, ftsTable)] = ok
+	ds.ftsMu.Unlock()
+}
@@ -0,0 +1,114 @@
+package v4
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/sjzar/chatlog/internal/errors"
+)
+
+// newTestShardDB 建一个临时 sqlite 文件，里面有一张 tableName 对应的消息表和一条
+// 消息，schema 对齐 ensureFTSIndex/searchMessagesInDB 用到的列
+func newTestShardDB(t *testing.T, tableName string) *sql.DB {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "message_0.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("打开临时数据库失败: %v", err)
+	}
+
+	schema := fmt.Sprintf(`
+		CREATE TABLE %s (
+			sort_seq INTEGER PRIMARY KEY,
+			local_type INTEGER,
+			real_sender_id INTEGER,
+			create_time INTEGER,
+			message_content TEXT,
+			packed_info_data BLOB,
+			status INTEGER
+		);
+		CREATE TABLE Name2Id (user_name TEXT);
+		INSERT INTO %s (sort_seq, local_type, real_sender_id, create_time, message_content, packed_info_data, status)
+		VALUES (1, 1, 0, 1700000000, 'hello world', NULL, 1);
+	`, tableName, tableName)
+	if _, err := db.Exec(schema); err != nil {
+		t.Fatalf("初始化 schema 失败: %v", err)
+	}
+
+	return db
+}
+
+func TestFtsTriggersKeyDiffersByFilePath(t *testing.T) {
+	a := ftsTriggersKey("shard1.db", "Msg_abc_fts")
+	b := ftsTriggersKey("shard2.db", "Msg_abc_fts")
+	if a == b {
+		t.Fatalf("同名虚拟表在不同分片下的 key 不应该相同: %s == %s", a, b)
+	}
+}
+
+// TestEnsureFTSIndexIsolatedPerShard 验证同一个 talker 在两个不同分片里各自独立
+// 的可写性探测结果不会互相覆盖（回归 chunk0-1 的 bug：ftsTriggersOK 只按表名做
+// key，一个分片的探测结果会错误地套用到所有同名分片）
+func TestEnsureFTSIndexIsolatedPerShard(t *testing.T) {
+	ds := &DataSource{ftsTriggersOK: make(map[string]bool)}
+	tableName := "Msg_deadbeef"
+
+	db1 := newTestShardDB(t, tableName)
+	defer db1.Close()
+	db2 := newTestShardDB(t, tableName)
+	defer db2.Close()
+
+	ctx := context.Background()
+
+	if _, err := ds.ensureFTSIndex(ctx, db1, "shard1.db", tableName); err != nil {
+		t.Fatalf("shard1 建索引失败: %v", err)
+	}
+	if _, err := ds.ensureFTSIndex(ctx, db2, "shard2.db", tableName); err != nil {
+		t.Fatalf("shard2 建索引失败: %v", err)
+	}
+
+	ftsTable := tableName + ftsTableSuffix
+
+	ds.ftsMu.Lock()
+	defer ds.ftsMu.Unlock()
+
+	if !ds.ftsTriggersOK[ftsTriggersKey("shard1.db", ftsTable)] {
+		t.Errorf("shard1 的触发器应该标记为可写")
+	}
+	if !ds.ftsTriggersOK[ftsTriggersKey("shard2.db", ftsTable)] {
+		t.Errorf("shard2 的触发器应该标记为可写")
+	}
+	if len(ds.ftsTriggersOK) != 2 {
+		t.Errorf("两个分片应该各自有一条独立记录，实际 %d 条", len(ds.ftsTriggersOK))
+	}
+}
+
+// TestEnsureFTSIndexReadOnlySkipsCreate 验证 ReadOnly 模式下不会尝试
+// CREATE VIRTUAL TABLE / INSERT ... SELECT，而是直接返回一个可识别的错误
+func TestEnsureFTSIndexReadOnlySkipsCreate(t *testing.T) {
+	ds := &DataSource{
+		ftsTriggersOK: make(map[string]bool),
+		opts:          DataSourceOptions{ReadOnly: true},
+	}
+	tableName := "Msg_readonly"
+
+	db := newTestShardDB(t, tableName)
+	defer db.Close()
+
+	_, err := ds.ensureFTSIndex(context.Background(), db, "ro.db", tableName)
+	if err != errors.ErrFTSIndexUnavailableReadOnly {
+		t.Fatalf("期望 errors.ErrFTSIndexUnavailableReadOnly，实际 %v", err)
+	}
+
+	var exists bool
+	row := db.QueryRow("SELECT 1 FROM sqlite_master WHERE type='table' AND name=?", tableName+ftsTableSuffix)
+	if scanErr := row.Scan(&exists); scanErr != sql.ErrNoRows {
+		t.Fatalf("ReadOnly 模式下不应该建出 FTS 虚拟表")
+	}
+}
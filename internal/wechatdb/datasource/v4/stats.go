@@ -0,0 +1,435 @@
+package v4
+
+import (
+	"container/list"
+	"context"
+	"crypto/md5"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/errors"
+)
+
+// 分组维度
+const (
+	StatsGroupByDay    = "day"
+	StatsGroupByHour   = "hour"
+	StatsGroupBySender = "sender"
+	StatsGroupByType   = "type"
+	// StatsGroupByTalker 按会话（Msg_<md5> 表）分组，用于统计总消息数/活跃 talker
+	// 排行；Bucket.Key 是解析出来的 talker 用户名，解析不出来时退化为 md5
+	StatsGroupByTalker = "talker"
+)
+
+// 统计指标
+const (
+	StatsMetricCount = "count"
+	StatsMetricBytes = "bytes"
+)
+
+// StatsRequest 描述一次统计查询的范围和维度
+type StatsRequest struct {
+	StartTime time.Time
+	EndTime   time.Time
+	// Talker 为空时统计时间范围内所有会话
+	Talker string
+	// GroupBy 为 day|hour|sender|type|talker，默认为 day。talker 统计的是整个
+	// Talker 为空（所有会话）时各个会话自己的总量，Talker 非空时没有意义（本来就
+	// 只有一个会话），会退化成单一 bucket
+	GroupBy string
+	// Metric 为 count|bytes，默认为 count
+	Metric string
+}
+
+// StatsBucket 是按 GroupBy 分组后的一条统计结果
+type StatsBucket struct {
+	Key   string
+	Value int64
+}
+
+// StatsResult 是 GetStats 的返回值
+type StatsResult struct {
+	Buckets []StatsBucket
+	Total   int64
+}
+
+// GetStats 在不加载完整消息行的前提下，对时间范围内的消息做聚合统计：按天/按小时
+// 的直方图、活跃 talker 排行、群聊内按发送者的计数等。按 getDBInfosForTimeRange
+// 选出的分片并发聚合，再在 Go 侧合并；每个分片的聚合结果按 (filePath, mtime,
+// query-hash) 缓存在 LRU 里，老分片一旦落盘就不会再变化，重复的仪表盘查询可以
+// 直接命中缓存。
+func (ds *DataSource) GetStats(ctx context.Context, req StatsRequest) (*StatsResult, error) {
+	if req.GroupBy == "" {
+		req.GroupBy = StatsGroupByDay
+	}
+	if req.Metric == "" {
+		req.Metric = StatsMetricCount
+	}
+
+	dbInfos := ds.getDBInfosForTimeRange(req.StartTime, req.EndTime)
+	if len(dbInfos) == 0 {
+		return nil, errors.TimeRangeNotFound(req.StartTime, req.EndTime)
+	}
+
+	merged := make(map[string]int64)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, dbInfo := range dbInfos {
+		wg.Add(1)
+		go func(dbInfo MessageDBInfo) {
+			defer wg.Done()
+
+			buckets, err := ds.getShardStats(ctx, dbInfo, req)
+			if err != nil {
+				log.Err(err).Msgf("统计数据库 %s 失败", dbInfo.FilePath)
+				return
+			}
+
+			mu.Lock()
+			for _, b := range buckets {
+				merged[b.Key] += b.Value
+			}
+			mu.Unlock()
+		}(dbInfo)
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	result := &StatsResult{Buckets: make([]StatsBucket, 0, len(merged))}
+	for key, value := range merged {
+		result.Buckets = append(result.Buckets, StatsBucket{Key: key, Value: value})
+		result.Total += value
+	}
+	sort.Slice(result.Buckets, func(i, j int) bool {
+		return result.Buckets[i].Key < result.Buckets[j].Key
+	})
+
+	return result, nil
+}
+
+// getShardStats 计算单个分片的统计结果，优先走 statsCache
+func (ds *DataSource) getShardStats(ctx context.Context, dbInfo MessageDBInfo, req StatsRequest) ([]StatsBucket, error) {
+	fi, err := os.Stat(dbInfo.FilePath)
+	if err != nil {
+		return nil, errors.DBConnectFailed(dbInfo.FilePath, err)
+	}
+
+	cacheKey := statsCacheKey(dbInfo.FilePath, fi.ModTime(), req)
+	if cached, ok := ds.statsCache.get(cacheKey); ok {
+		return cached, nil
+	}
+
+	db, ok := ds.getMessageDB(dbInfo.FilePath)
+	if !ok {
+		return nil, errors.DBConnectFailed(dbInfo.FilePath, nil)
+	}
+
+	tables, err := ds.statsTables(ctx, db, req.Talker)
+	if err != nil {
+		return nil, err
+	}
+
+	// talker 分组没有 SQL 层面的 GROUP BY 列可用（表名只编码了 md5(talker)），
+	// 每张 Msg_<md5> 表本身就是一个分组，走单独的聚合路径
+	if req.GroupBy == StatsGroupByTalker {
+		result, err := ds.queryTalkerStats(ctx, db, tables, req)
+		if err != nil {
+			return nil, err
+		}
+		ds.statsCache.set(cacheKey, result)
+		return result, nil
+	}
+
+	merged := make(map[string]int64)
+	for _, table := range tables {
+		buckets, err := ds.queryTableStats(ctx, db, table, req)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range buckets {
+			merged[b.Key] += b.Value
+		}
+	}
+
+	result := make([]StatsBucket, 0, len(merged))
+	for key, value := range merged {
+		result = append(result, StatsBucket{Key: key, Value: value})
+	}
+
+	ds.statsCache.set(cacheKey, result)
+
+	return result, nil
+}
+
+// tableMD5 从 Msg_<md5> 表名里取出 talker 的 md5
+func tableMD5(table string) string {
+	return strings.TrimPrefix(table, "Msg_")
+}
+
+// queryTalkerStats 对每个 talker 各自的消息表做一次整体聚合（不再按天/小时细
+// 分），Bucket.Key 是解析出来的 talker 用户名，用于总消息数/活跃 talker 排行
+func (ds *DataSource) queryTalkerStats(ctx context.Context, db *sql.DB, tables []string, req StatsRequest) ([]StatsBucket, error) {
+	names, err := ds.resolveTalkerNames(ctx, tables)
+	if err != nil {
+		return nil, err
+	}
+
+	metricExpr, err := statsMetricExpr(req.Metric)
+	if err != nil {
+		return nil, err
+	}
+
+	buckets := make([]StatsBucket, 0, len(tables))
+	for _, table := range tables {
+		query := fmt.Sprintf(`SELECT %s FROM %s m WHERE m.create_time >= ? AND m.create_time <= ?`, metricExpr, table)
+
+		var value sql.NullInt64
+		err := db.QueryRowContext(ctx, query, req.StartTime.Unix(), req.EndTime.Unix()).Scan(&value)
+		if err != nil {
+			if strings.Contains(err.Error(), "no such table") {
+				continue
+			}
+			return nil, errors.QueryFailed(query, err)
+		}
+		if !value.Valid || value.Int64 == 0 {
+			continue
+		}
+
+		key := names[tableMD5(table)]
+		if key == "" {
+			key = tableMD5(table)
+		}
+
+		buckets = append(buckets, StatsBucket{Key: key, Value: value.Int64})
+	}
+
+	return buckets, nil
+}
+
+// resolveTalkerNames 把 Msg_<md5> 表名反解成真实的 talker 用户名：表名只编码了
+// md5(talker)，这里枚举 contact.db / session.db 里出现过的用户名，重新算一遍
+// md5 去匹配需要的表，匹配不上的 talker（表仍存在但联系人/会话记录已被清理）
+// 调用方会退化成直接展示 md5
+func (ds *DataSource) resolveTalkerNames(ctx context.Context, tables []string) (map[string]string, error) {
+	need := make(map[string]bool, len(tables))
+	for _, table := range tables {
+		need[tableMD5(table)] = true
+	}
+
+	names := make(map[string]string, len(tables))
+	assign := func(candidate string) {
+		if candidate == "" {
+			return
+		}
+		sum := md5.Sum([]byte(candidate))
+		key := hex.EncodeToString(sum[:])
+		if need[key] {
+			names[key] = candidate
+		}
+	}
+
+	if ds.contactDb != nil {
+		rows, err := ds.contactDb.QueryContext(ctx, `SELECT username FROM contact`)
+		if err == nil {
+			for rows.Next() {
+				var username string
+				if rows.Scan(&username) == nil {
+					assign(username)
+				}
+			}
+			rows.Close()
+		}
+	}
+
+	if ds.sessionDb != nil {
+		rows, err := ds.sessionDb.QueryContext(ctx, `SELECT username FROM SessionTable`)
+		if err == nil {
+			for rows.Next() {
+				var username string
+				if rows.Scan(&username) == nil {
+					assign(username)
+				}
+			}
+			rows.Close()
+		}
+	}
+
+	return names, nil
+}
+
+// statsTables 返回需要参与统计的 Msg_<md5> 表名：指定了 talker 时只统计对应的
+// 表，否则枚举该数据库内所有消息表（排除 FTS5 虚拟表及其 shadow 表）
+func (ds *DataSource) statsTables(ctx context.Context, db *sql.DB, talker string) ([]string, error) {
+	if talker != "" {
+		talkerMd5Bytes := md5.Sum([]byte(talker))
+		return []string{"Msg_" + hex.EncodeToString(talkerMd5Bytes[:])}, nil
+	}
+
+	rows, err := db.QueryContext(ctx,
+		`SELECT name FROM sqlite_master WHERE type='table' AND name LIKE 'Msg_%' AND name NOT LIKE '%_fts%'`)
+	if err != nil {
+		return nil, errors.QueryFailed("", err)
+	}
+	defer rows.Close()
+
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, errors.ScanRowFailed(err)
+		}
+		tables = append(tables, name)
+	}
+
+	return tables, nil
+}
+
+// queryTableStats 对单张 Msg_<md5> 表执行 GROUP BY 聚合
+func (ds *DataSource) queryTableStats(ctx context.Context, db *sql.DB, table string, req StatsRequest) ([]StatsBucket, error) {
+	groupExpr, joinName2Id, err := statsGroupExpr(req.GroupBy)
+	if err != nil {
+		return nil, err
+	}
+
+	metricExpr, err := statsMetricExpr(req.Metric)
+	if err != nil {
+		return nil, err
+	}
+
+	join := ""
+	if joinName2Id {
+		join = "LEFT JOIN Name2Id n ON m.real_sender_id = n.rowid"
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s AS bucket, %s AS value
+		FROM %s m
+		%s
+		WHERE m.create_time >= ? AND m.create_time <= ?
+		GROUP BY bucket
+	`, groupExpr, metricExpr, table, join)
+
+	rows, err := db.QueryContext(ctx, query, req.StartTime.Unix(), req.EndTime.Unix())
+	if err != nil {
+		// 如果表不存在，SQLite 会返回错误
+		if strings.Contains(err.Error(), "no such table") {
+			return []StatsBucket{}, nil
+		}
+		return nil, errors.QueryFailed(query, err)
+	}
+	defer rows.Close()
+
+	buckets := []StatsBucket{}
+	for rows.Next() {
+		var b StatsBucket
+		if err := rows.Scan(&b.Key, &b.Value); err != nil {
+			return nil, errors.ScanRowFailed(err)
+		}
+		buckets = append(buckets, b)
+	}
+
+	return buckets, nil
+}
+
+func statsGroupExpr(groupBy string) (expr string, joinName2Id bool, err error) {
+	switch groupBy {
+	case StatsGroupByDay:
+		return "strftime('%Y-%m-%d', m.create_time, 'unixepoch', 'localtime')", false, nil
+	case StatsGroupByHour:
+		return "strftime('%Y-%m-%d %H:00', m.create_time, 'unixepoch', 'localtime')", false, nil
+	case StatsGroupBySender:
+		return "IFNULL(n.user_name, '')", true, nil
+	case StatsGroupByType:
+		return "CAST(m.local_type AS TEXT)", false, nil
+	default:
+		return "", false, errors.StatsGroupByUnsupported(groupBy)
+	}
+}
+
+func statsMetricExpr(metric string) (string, error) {
+	switch metric {
+	case StatsMetricCount:
+		return "COUNT(*)", nil
+	case StatsMetricBytes:
+		return "SUM(LENGTH(m.message_content))", nil
+	default:
+		return "", errors.StatsMetricUnsupported(metric)
+	}
+}
+
+// statsCacheKey 组合分片路径、mtime 与查询条件的哈希，使老分片的统计结果可以
+// 安全地长期缓存
+func statsCacheKey(filePath string, mtime time.Time, req StatsRequest) string {
+	raw := fmt.Sprintf("%s|%d|%d|%d|%s|%s|%s",
+		filePath, mtime.UnixNano(), req.StartTime.Unix(), req.EndTime.Unix(), req.Talker, req.GroupBy, req.Metric)
+	sum := md5.Sum([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// statsLRU 是一个按最近最少使用淘汰的缓存，value 为某个分片在某个查询条件下的
+// 聚合结果
+type statsLRU struct {
+	mu       sync.Mutex
+	capacity int
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+type statsLRUEntry struct {
+	key   string
+	value []StatsBucket
+}
+
+func newStatsLRU(capacity int) *statsLRU {
+	return &statsLRU{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (c *statsLRU) get(key string) ([]StatsBucket, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return el.Value.(*statsLRUEntry).value, true
+}
+
+func (c *statsLRU) set(key string, value []StatsBucket) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.ll.MoveToFront(el)
+		el.Value.(*statsLRUEntry).value = value
+		return
+	}
+
+	el := c.ll.PushFront(&statsLRUEntry{key: key, value: value})
+	c.items[key] = el
+
+	if c.ll.Len() > c.capacity {
+		oldest := c.ll.Back()
+		if oldest != nil {
+			c.ll.Remove(oldest)
+			delete(c.items, oldest.Value.(*statsLRUEntry).key)
+		}
+	}
+}